@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"sort"
+
+	"twitterDownload/pkg/download"
+	"twitterDownload/pkg/export"
+	"twitterDownload/pkg/utils"
+)
+
+// exportMedia writes the media of every archived user folder, or only of
+// the named users, into one file. Without names, media the download record
+// knows but no sidecar lists are exported too.
+func exportMedia(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	formatName := flags.String("format", "jsonl", "export format: csv or jsonl")
+	out := flags.String("o", "", "file to write, default export.<ext> in the output directory")
+	hash := flags.Bool("hash", true, "add the SHA-256 of files on the local disk")
+	if err := flags.Parse(args); errors.Is(err, flag.ErrHelp) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	format, err := export.ParseFormat(*formatName)
+	if err != nil {
+		return err
+	}
+	if *out == "" {
+		*out = filepath.Join(settings.OutputDir, "export"+format.Ext())
+	}
+	wanted := make(map[string]bool)
+	for _, name := range flags.Args() {
+		wanted[name] = true
+	}
+
+	var records []export.Record
+	seen := make(map[string]bool)
+	for _, root := range outputDirs() {
+		dirs, err := userDirs(root)
+		if err != nil {
+			continue
+		}
+		for _, dir := range dirs {
+			if !wantsDir(dir, wanted) {
+				continue
+			}
+			for _, sub := range []string{"", "likes"} {
+				entries, err := download.ReadIndex(filepath.Join(dir, sub))
+				if err != nil {
+					return fmt.Errorf("%s: %w", filepath.Join(dir, sub), err)
+				}
+				for _, entry := range entries {
+					r := export.FromEntry(entry)
+					seen[r.URL] = true
+					records = append(records, r)
+				}
+			}
+		}
+	}
+	if len(wanted) == 0 {
+		var rest []export.Record
+		for url, location := range ledger.Entries() {
+			if !seen[url] && !download.IsThumbKey(url) {
+				rest = append(rest, export.FromLedger(url, location))
+			}
+		}
+		sort.Slice(rest, func(i, j int) bool { return rest[i].URL < rest[j].URL })
+		records = append(records, rest...)
+	}
+
+	e, err := export.Create(format, *out)
+	if err != nil {
+		return err
+	}
+	for i := range records {
+		if ctx.Err() != nil {
+			return errors.Join(ctx.Err(), e.Abort())
+		}
+		if *hash && records[i].LocalPath != "" && records[i].SHA256 == "" {
+			if records[i].SHA256, err = utils.HashFile(records[i].LocalPath); err != nil {
+				slog.Warn("hash file", "path", records[i].LocalPath, "err", err)
+			}
+		}
+		if err := e.Write(records[i]); err != nil {
+			return errors.Join(err, e.Close())
+		}
+	}
+	if err := e.Close(); err != nil {
+		return err
+	}
+	slog.Info("export written", "path", *out, "format", format, "media", len(records))
+	return nil
+}
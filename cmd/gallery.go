@@ -0,0 +1,106 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"twitterDownload/pkg/download"
+	"twitterDownload/pkg/gallery"
+	"twitterDownload/pkg/user"
+)
+
+// outputDirs lists the output folder of the settings followed by the other
+// folders user entries save to
+func outputDirs() []string {
+	dirs := []string{settings.OutputDir}
+	seen := map[string]bool{filepath.Clean(settings.OutputDir): true}
+	for _, entry := range settings.UserList {
+		if dir := filepath.Clean(settings.ForUser(entry).OutputDir); !seen[dir] {
+			seen[dir] = true
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
+
+// userDirs lists the archived user folders of an output folder, the ones
+// holding a profile or a media index
+func userDirs(root string) ([]string, error) {
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return nil, err
+	}
+	var dirs []string
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		dir := filepath.Join(root, entry.Name())
+		for _, marker := range []string{user.ProfileDirName, download.IndexFileName} {
+			if _, err := os.Stat(filepath.Join(dir, marker)); err == nil {
+				dirs = append(dirs, dir)
+				break
+			}
+		}
+	}
+	return dirs, nil
+}
+
+// wantsDir reports whether a user folder belongs to one of the wanted users,
+// given by screen name or user ID. Every folder is wanted when none are
+// named.
+func wantsDir(dir string, wanted map[string]bool) bool {
+	if len(wanted) == 0 || wanted[filepath.Base(dir)] {
+		return true
+	}
+	snapshot, _, _ := user.LoadLatestSnapshot(dir)
+	return snapshot.UserName != "" && wanted[snapshot.UserName]
+}
+
+// exportHTML writes a gallery into every archived user folder, or only into
+// those of the named users, and an index page into each output folder
+func exportHTML(names []string) error {
+	wanted := make(map[string]bool)
+	for _, name := range names {
+		wanted[name] = true
+	}
+	recordCSV := filepath.Join(settings.OutputDir, "record.csv")
+
+	var errs []error
+	for _, root := range outputDirs() {
+		dirs, err := userDirs(root)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		var pages []gallery.Page
+		for _, dir := range dirs {
+			page, err := gallery.Load(dir, recordCSV)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", dir, err))
+				continue
+			}
+			if len(wanted) > 0 && !wanted[page.UserName] && !wanted[page.UserId] {
+				continue
+			}
+			if err := gallery.Write(page); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", dir, err))
+				continue
+			}
+			slog.Info("gallery written", "user", page.UserName, "media", len(page.Items), "path", filepath.Join(dir, gallery.PageName))
+			pages = append(pages, page)
+		}
+		if len(pages) > 0 {
+			if err := gallery.WriteIndex(root, pages); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
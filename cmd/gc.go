@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"twitterDownload/pkg/archive"
+	"twitterDownload/pkg/client"
+	"twitterDownload/pkg/config"
+	"twitterDownload/pkg/download"
+	"twitterDownload/pkg/progress"
+	"twitterDownload/pkg/user"
+)
+
+// collectGarbage prunes the download record of deleted files, removes
+// unfinished downloads and prints the disk space of every user folder
+func collectGarbage(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("gc", flag.ContinueOnError)
+	requeue := flags.Bool("requeue", false, "download deleted files again when their media.jsonl entry is known")
+	partAge := flags.Duration("part-age", archive.DefaultPartAge, "keep unfinished downloads modified within this time")
+	if err := flags.Parse(args); errors.Is(err, flag.ErrHelp) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	var dirs []string
+	for _, root := range outputDirs() {
+		found, err := userDirs(root)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		dirs = append(dirs, found...)
+	}
+	result, err := archive.GC(ctx, archive.GCOptions{Ledger: ledger, Dirs: dirs, PartAge: *partAge, DryRun: dryRun})
+	printGC(os.Stdout, result)
+	if err != nil {
+		return err
+	}
+	if dryRun {
+		return nil
+	}
+	if err := ledger.SaveToFile(); err != nil {
+		return err
+	}
+	if !*requeue {
+		return nil
+	}
+	return requeueMissing(ctx, result.Missing)
+}
+
+// requeueMissing downloads the deleted files whose media item is known
+// into the folders they were in
+func requeueMissing(ctx context.Context, missing []archive.Missing) error {
+	byDir := make(map[string][]download.MediaItem)
+	for _, m := range missing {
+		if m.Item != nil {
+			byDir[m.Dir] = append(byDir[m.Dir], *m.Item)
+		}
+	}
+	if len(byDir) == 0 {
+		return nil
+	}
+	dirs := make([]string, 0, len(byDir))
+	for dir := range byDir {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+
+	c, err := newClient(settings.ForUser(config.UserEntry{}))
+	if err != nil {
+		return err
+	}
+	var errs []error
+	done, failed := 0, 0
+	for _, dir := range dirs {
+		items := byDir[dir]
+		u := user.UserInfo{UserName: items[0].User, SaveDir: dir + string(os.PathSeparator)}
+		results, err := c.DownloadItems(ctx, u, items)
+		for _, r := range results {
+			switch r.Status {
+			case client.MediaDone:
+				done++
+			case client.MediaFailed:
+				failed++
+			}
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", dir, err))
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	fmt.Printf("re-queued %d deleted files: %d downloaded, %d failed\n", done+failed, done, failed)
+	return errors.Join(append(errs, c.Close())...)
+}
+
+func printGC(w io.Writer, r archive.GCResult) {
+	verb := "removed"
+	if dryRun {
+		verb = "would remove"
+	}
+	fmt.Fprintf(w, "download record: %d local files checked, %d stored elsewhere, %s %d deleted files\n", r.Checked, r.Unchecked, verb, len(r.Missing))
+	for _, m := range r.Missing {
+		if m.Location == "" {
+			fmt.Fprintf(w, "  deleted %s\n", m.URL)
+		} else {
+			fmt.Fprintf(w, "  deleted %s (%s)\n", m.Location, m.URL)
+		}
+	}
+	fmt.Fprintf(w, "unfinished downloads: %s %d (%s)\n", verb, len(r.Parts), progress.FormatBytes(r.PartBytes))
+	for _, path := range r.Parts {
+		fmt.Fprintf(w, "  %s\n", path)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "user\tfiles\tsize\t")
+	var total archive.Usage
+	for _, u := range r.Usage {
+		fmt.Fprintf(tw, "%s\t%d\t%s\t\n", u.User, u.Files, progress.FormatBytes(u.Bytes))
+		total.Files += u.Files
+		total.Bytes += u.Bytes
+	}
+	fmt.Fprintf(tw, "total\t%d\t%s\t\n", total.Files, progress.FormatBytes(total.Bytes))
+	tw.Flush()
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+
+	"twitterDownload/pkg/archive"
+	"twitterDownload/pkg/config"
+	"twitterDownload/pkg/download"
+	"twitterDownload/pkg/progress"
+)
+
+// importArchive adds the media files in the given folders to the download
+// record and prints what was found in each
+func importArchive(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	offline := flags.Bool("offline", false, "do not look tweets up, leaving files named <tweet id>_<n> unresolved")
+	if err := flags.Parse(args); errors.Is(err, flag.ErrHelp) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		return errors.New("import needs at least one folder")
+	}
+	if !*offline {
+		if err := settings.ValidateCookie(); err != nil {
+			return fmt.Errorf("looking tweets up needs a session, or use -offline: %w", err)
+		}
+	}
+
+	c, err := newClient(settings.ForUser(config.UserEntry{}))
+	if err != nil {
+		return err
+	}
+	opts := archive.Options{Ledger: ledger, DryRun: dryRun}
+	if !*offline {
+		opts.Resolve = func(ctx context.Context, tweetId string) ([]download.MediaItem, error) {
+			_, items, err := c.FetchTweet(ctx, tweetId)
+			// the request URL would drown the reason in the listing
+			var urlErr *url.Error
+			if errors.As(err, &urlErr) {
+				err = fmt.Errorf("tweet %s: %w", tweetId, urlErr.Err)
+			}
+			return items, err
+		}
+	}
+
+	var errs []error
+	for _, dir := range flags.Args() {
+		result, err := archive.Import(ctx, dir, opts)
+		printImport(os.Stdout, dir, result)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", dir, err))
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	// files imported before an error or an interrupt stay recorded
+	return errors.Join(append(errs, c.Close())...)
+}
+
+func printImport(w io.Writer, dir string, r archive.Result) {
+	verb := "imported"
+	if dryRun {
+		verb = "would import"
+	}
+	fmt.Fprintf(w, "%s: %d media files, %s %d (%s), %d already recorded, %d unresolved, %d duplicate groups, %d orphans\n",
+		dir, r.Files, verb, r.Imported, progress.FormatBytes(r.Bytes), r.Known, len(r.Unresolved), len(r.Duplicates), len(r.Orphans))
+	for _, u := range r.Unresolved {
+		fmt.Fprintf(w, "  unresolved %s: %s\n", u.Path, u.Reason)
+	}
+	for _, group := range r.Duplicates {
+		fmt.Fprintf(w, "  duplicates %v\n", group)
+	}
+	for _, path := range r.Orphans {
+		fmt.Fprintf(w, "  orphan %s\n", path)
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+
+	"twitterDownload/pkg/client"
+	"twitterDownload/pkg/config"
+	"twitterDownload/pkg/download"
+)
+
+// listUsers prints the media of the named users, or of every enabled user
+// of the settings when names is empty, as one JSON object per line. The
+// filters of each user apply, nothing is downloaded.
+func listUsers(ctx context.Context, w io.Writer, names []string) error {
+	entries := make([]config.UserEntry, 0, len(names))
+	for _, name := range names {
+		entries = append(entries, config.UserEntry{User: name})
+	}
+	if len(names) == 0 {
+		for _, entry := range settings.UserList {
+			if entry.IsEnabled() {
+				entries = append(entries, entry)
+			}
+		}
+	}
+
+	enc := json.NewEncoder(w)
+	var errs []error
+	for _, entry := range entries {
+		entry = settings.ForUser(entry)
+		c, err := newClient(entry)
+		if err != nil {
+			return err
+		}
+		userInfo, err := c.FetchUser(ctx, entry.User)
+		if err != nil {
+			if errors.Is(err, context.Canceled) {
+				return err
+			}
+			slog.Warn("user skipped", "user", entry.User, "reason", skipReason(err), "err", err)
+			errs = append(errs, err)
+			continue
+		}
+		filters := entry.Filters()
+		err = c.IterateUserMedia(ctx, userInfo, func(item download.MediaItem) error {
+			if filters.Since != "" && item.TweetDate != "" && item.TweetDate < filters.Since {
+				// the rest of a timeline sorted by tweet is older still
+				if item.Source.OrderedByTweet() {
+					return client.SkipSource
+				}
+				return nil
+			}
+			if !filters.AllowsMediaType(item.Type) {
+				return nil
+			}
+			return enc.Encode(item)
+		})
+		if errors.Is(err, context.Canceled) {
+			return err
+		}
+		if err != nil {
+			slog.Error("list media", "user", entry.User, "err", err)
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -2,27 +2,143 @@ package main
 
 import (
 	"bufio"
+	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"log/slog"
+	"path/filepath"
+	"twitterDownload/pkg/client"
 	"twitterDownload/pkg/config"
 	"twitterDownload/pkg/download"
+	"twitterDownload/pkg/logging"
+	"twitterDownload/pkg/progress"
+	"twitterDownload/pkg/report"
+	"twitterDownload/pkg/sink"
+	"twitterDownload/pkg/storage"
 	"twitterDownload/pkg/user"
-	"twitterDownload/pkg/utils"
 
 	"os"
-	"sync"
+	"time"
 )
 
-var task sync.WaitGroup
+// settings is the loaded configuration of this run
+var settings config.Settings
 
-var csvList = []utils.CSV{}
+// ledger records the media downloaded by every client of this run
+var ledger storage.URLStorage
 
-func downloadByUser(userName string) {
-	userInfo,_ := user.FetchUserInfo(userName)
-	userInfo.SaveDir = userName + "/"
-	download.DownloadTwitterMedia(&userInfo, &csvList)
-};
+// runReport collects the errors of every user of this run
+var runReport = report.NewReport()
 
-func menu() {
+// tracker aggregates the progress events for the live display and the
+// summary, it is nil until downloading starts
+var tracker *progress.Tracker
+
+var showProgress bool
+
+// syncOverride is the --sync flag, it wins over the sync mode of every user
+var syncOverride string
+
+// dryRun is the --dry-run flag: crawl and plan, but write nothing
+var dryRun bool
+
+// newClient creates the client downloading one user entry, with the entry's
+// sync mode, filters, sources and output folder
+func newClient(entry config.UserEntry) (*client.Client, error) {
+	mode := entry.SyncMode
+	if syncOverride != "" {
+		mode = syncOverride
+	}
+	syncMode, err := download.ParseSyncMode(mode)
+	if err != nil {
+		return nil, err
+	}
+	var sources []download.Source
+	for _, name := range entry.Sources {
+		source, err := download.ParseSource(name)
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, source)
+	}
+	// a nil tracker must not end up as a non-nil progress.Sink
+	var events progress.Sink
+	if tracker != nil {
+		events = tracker
+	}
+	out, err := sink.Open(settings.Storage, entry.OutputDir, nil)
+	if err != nil {
+		return nil, err
+	}
+	return client.New(client.Options{
+		Cookie:       settings.Cookie,
+		APIBase:      settings.APIBase,
+		Concurrency:  settings.Concurrency,
+		OutputDir:    entry.OutputDir,
+		Ledger:       ledger,
+		Sink:         out,
+		RecordCSV:    filepath.Join(settings.OutputDir, "record.csv"),
+		NameTemplate: settings.NameTemplate,
+		ImageFormats: settings.ImageFormats,
+		SyncMode:     syncMode,
+		KnownPages:   settings.KnownPages,
+		Filters:      entry.Filters(),
+		Sources:      sources,
+		Events:       events,
+		DryRun:       dryRun,
+		Thumbnails:   thumbnailSize(),
+	})
+}
+
+func downloadByUser(ctx context.Context, entry config.UserEntry) error {
+	entry = settings.ForUser(entry)
+	c, err := newClient(entry)
+	if err != nil {
+		runReport.Add(report.Wrap(err, report.StageUser, entry.User, "", ""))
+		return err
+	}
+	result, err := c.DownloadUser(ctx, entry.User)
+	for _, e := range result.Errors {
+		runReport.Add(e)
+	}
+	if closeErr := c.Close(); closeErr != nil {
+		runReport.Add(report.Wrap(closeErr, report.StageSave, entry.User, "", ""))
+	}
+	return err
+}
+
+// startRun starts tracking the run and the live progress display, returning
+// the function that stops the display
+func startRun() func() {
+	tracker = progress.NewTracker()
+	if !showProgress {
+		return func() {}
+	}
+	renderer := progress.NewRenderer(os.Stdout, tracker)
+	renderer.Start()
+	return renderer.Stop
+}
+
+// skipReason describes why a user could not be crawled
+func skipReason(err error) string {
+	switch {
+	case errors.Is(err, context.Canceled):
+		return "interrupted"
+	case errors.Is(err, user.ErrUserNotFound):
+		return "not found (renamed or deleted)"
+	case errors.Is(err, user.ErrSuspended):
+		return "suspended"
+	case errors.Is(err, user.ErrProtected):
+		return "protected and not followed"
+	case errors.Is(err, user.ErrUnavailable):
+		return "unavailable"
+	default:
+		return "request failed"
+	}
+}
+
+func menu(ctx context.Context) {
 	fmt.Println("1. Get media by user")
 	fmt.Println("2. Get media by userList")
 	fmt.Println("3. Exit")
@@ -30,28 +146,209 @@ func menu() {
 	scanner := bufio.NewScanner(os.Stdin)
 	scanner.Scan()
 	choice := scanner.Text()
+	if ctx.Err() != nil {
+		return
+	}
 
 	switch choice {
 	case "1":
-		fmt.Println("Enter user name:")
+		fmt.Println("Enter user name (or id:<user id>):")
 		scanner.Scan()
 		username := scanner.Text()
-		downloadByUser(username)
+		if ctx.Err() != nil {
+			return
+		}
+		stopProgress := startRun()
+		err := downloadByUser(ctx, config.UserEntry{User: username})
+		stopProgress()
+		if err != nil {
+			fmt.Printf("skipped %s: %s (%v)\n", username, skipReason(err), err)
+		}
 	case "2":
-		// 调用其他功能
-		for _, user := range  config.SettingConfig.UserList {
-			downloadByUser(user)
+		var skipped []string
+		stopProgress := startRun()
+		for _, entry := range settings.UserList {
+			if ctx.Err() != nil {
+				break
+			}
+			if !entry.IsEnabled() {
+				slog.Info("user disabled in settings", "user", entry.User)
+				continue
+			}
+			if err := downloadByUser(ctx, entry); err != nil {
+				slog.Warn("user skipped", "user", entry.User, "reason", skipReason(err), "err", err)
+				skipped = append(skipped, entry.User+": "+skipReason(err))
+			}
+		}
+		stopProgress()
+		if len(skipped) > 0 {
+			fmt.Printf("%d of %d users skipped:\n", len(skipped), len(settings.UserList))
+			for _, line := range skipped {
+				fmt.Println("  " + line)
+			}
 		}
 	case "3":
-		task.Done()
+		return
 	default:
 		fmt.Println("Invalid choice")
-		menu()
+		menu(ctx)
 	}
 }
 
 func main() {
-	task.Add(1)
-	menu()
-	task.Wait()
+	quiet := flag.Bool("quiet", false, "only log warnings and errors")
+	verbose := flag.Bool("verbose", false, "log debug output including request traces")
+	logFormat := flag.String("log-format", "text", "log format: text or json")
+	logFile := flag.String("log-file", "", "write logs to this file instead of stderr")
+	flag.BoolVar(&showProgress, "progress", true, "show live crawl and download progress")
+	configPath := flag.String("config", "", "settings file, default $TWDL_CONFIG or "+config.DefaultPath)
+	profile := flag.String("profile", "", "use this entry of \"profiles\" in the settings, default $TWDL_PROFILE")
+	syncMode := flag.String("sync", "", "sync mode: full, incremental or backfill, overrides the settings")
+	knownPages := flag.Int("known-pages", 0, "stop an incremental sync after this many pages without new media, overrides the settings")
+	flag.BoolVar(&dryRun, "dry-run", false, "crawl and report what would be downloaded with estimated sizes, without writing anything")
+	flag.Parse()
+
+	closeLog, err := logging.Setup(logging.Options{
+		Format: *logFormat,
+		File:   *logFile,
+		Level:  logging.LevelFromFlags(*quiet, *verbose),
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	defer closeLog()
+
+	settings, err = config.Load(config.Options{Path: *configPath, Profile: *profile})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		closeLog()
+		os.Exit(2)
+	}
+	if *syncMode != "" {
+		if _, err := download.ParseSyncMode(*syncMode); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			closeLog()
+			os.Exit(2)
+		}
+		syncOverride = *syncMode
+	}
+	if *knownPages > 0 {
+		settings.KnownPages = *knownPages
+	}
+	if ledger, err = storage.OpenURLStore(filepath.Join(settings.OutputDir, "log.json")); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		closeLog()
+		os.Exit(2)
+	}
+
+	// commands working on the archive alone run without a session, import
+	// checks for itself whether it looks tweets up
+	switch flag.Arg(0) {
+	case "export", "export-html", "gc", "import", "thumbnails":
+	default:
+		if err := settings.ValidateCookie(); err != nil {
+			fmt.Fprintln(os.Stderr, "invalid settings:", err)
+			closeLog()
+			os.Exit(2)
+		}
+	}
+
+	if dryRun && (flag.Arg(0) == "watch" || flag.Arg(0) == "thumbnails") {
+		fmt.Fprintln(os.Stderr, "--dry-run cannot be used with "+flag.Arg(0))
+		closeLog()
+		os.Exit(2)
+	}
+
+	ctx := interruptContext()
+	switch flag.Arg(0) {
+	case "watch":
+		if err := watchUsers(ctx); err != nil {
+			slog.Error("watch", "err", err)
+			closeLog()
+			os.Exit(1)
+		}
+	case "list":
+		if err := listUsers(ctx, os.Stdout, flag.Args()[1:]); err != nil {
+			closeLog()
+			if errors.Is(err, context.Canceled) {
+				os.Exit(130)
+			}
+			os.Exit(1)
+		}
+		return
+	case "export-html":
+		if err := exportHTML(flag.Args()[1:]); err != nil {
+			slog.Error("export html", "err", err)
+			closeLog()
+			os.Exit(1)
+		}
+		return
+	case "export":
+		if err := exportMedia(ctx, flag.Args()[1:]); err != nil {
+			slog.Error("export", "err", err)
+			closeLog()
+			if errors.Is(err, context.Canceled) {
+				os.Exit(130)
+			}
+			os.Exit(1)
+		}
+		return
+	case "import":
+		if err := importArchive(ctx, flag.Args()[1:]); err != nil {
+			slog.Error("import", "err", err)
+			closeLog()
+			if errors.Is(err, context.Canceled) {
+				os.Exit(130)
+			}
+			os.Exit(1)
+		}
+		return
+	case "gc":
+		if err := collectGarbage(ctx, flag.Args()[1:]); err != nil {
+			slog.Error("gc", "err", err)
+			closeLog()
+			if errors.Is(err, context.Canceled) {
+				os.Exit(130)
+			}
+			os.Exit(1)
+		}
+		return
+	case "thumbnails":
+		if err := makeThumbnails(ctx, flag.Args()[1:]); err != nil {
+			slog.Error("make thumbnails", "err", err)
+			closeLog()
+			if errors.Is(err, context.Canceled) {
+				os.Exit(130)
+			}
+			os.Exit(1)
+		}
+		return
+	default:
+		menu(ctx)
+	}
+	if tracker == nil {
+		return
+	}
+
+	summary := report.NewSummary(tracker.Snapshot(), runReport.Errors(), time.Now())
+	summary.PrintTable(os.Stdout)
+	// a plan is not part of the archive's history, only real runs are kept
+	if !dryRun {
+		if path, err := summary.WriteJSON(settings.OutputDir); err != nil {
+			slog.Error("write run summary", "err", err)
+		} else {
+			slog.Info("run summary saved", "path", path)
+		}
+	}
+	// an interrupted download run exits like a shell job would, while a
+	// stopped watcher has done what it was asked to
+	if ctx.Err() != nil && flag.Arg(0) != "watch" {
+		closeLog()
+		os.Exit(130)
+	}
+	if summary.Failed() {
+		closeLog()
+		os.Exit(1)
+	}
 }
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// interruptContext returns a context that the first SIGINT or SIGTERM
+// cancels, so no new work starts, files in flight are aborted and all state
+// is saved. A second signal exits at once in case finishing up hangs.
+func interruptContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		slog.Warn("interrupted, saving download record and sync state; interrupt again to quit immediately")
+		cancel()
+		<-signals
+		slog.Error("interrupted twice, exiting without saving")
+		os.Exit(130)
+	}()
+	return ctx
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"twitterDownload/pkg/config"
+	"twitterDownload/pkg/thumb"
+)
+
+// thumbnailSize is the edge length thumbnails are made with during
+// downloads, zero when they are disabled
+func thumbnailSize() int {
+	if !settings.Thumbnails.Enabled {
+		return 0
+	}
+	return cmp.Or(settings.Thumbnails.Size, thumb.DefaultSize)
+}
+
+// makeThumbnails adds the missing thumbnails of every archived user folder,
+// or only of the named users, whether or not thumbnails are enabled
+func makeThumbnails(ctx context.Context, names []string) error {
+	wanted := make(map[string]bool)
+	for _, name := range names {
+		wanted[name] = true
+	}
+
+	var errs []error
+	for _, root := range outputDirs() {
+		dirs, err := userDirs(root)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		c, err := newClient(settings.ForUser(config.UserEntry{OutputDir: root}))
+		if err != nil {
+			return err
+		}
+		for _, dir := range dirs {
+			if !wantsDir(dir, wanted) {
+				continue
+			}
+			for _, sub := range []string{"", "likes"} {
+				made, failed, err := c.MakeThumbnails(ctx, filepath.Join(dir, sub))
+				for _, e := range failed {
+					errs = append(errs, e)
+				}
+				if err != nil {
+					errs = append(errs, fmt.Errorf("%s: %w", dir, err))
+				}
+				if made > 0 {
+					slog.Info("thumbnails made", "dir", filepath.Join(dir, sub), "count", made)
+				}
+			}
+			if ctx.Err() != nil {
+				break
+			}
+		}
+		errs = append(errs, c.Close())
+		if ctx.Err() != nil {
+			return errors.Join(append(errs, ctx.Err())...)
+		}
+	}
+	return errors.Join(errs...)
+}
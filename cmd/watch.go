@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"path/filepath"
+	"time"
+
+	"twitterDownload/pkg/config"
+	"twitterDownload/pkg/download"
+	"twitterDownload/pkg/watch"
+)
+
+// watchUsers polls every enabled user of the settings on its interval until
+// ctx is cancelled. Polls always sync incrementally, only the newest media
+// is of interest between two polls.
+func watchUsers(ctx context.Context) error {
+	syncOverride = string(download.SyncIncremental)
+	entries := make(map[string]config.UserEntry)
+	var targets []watch.Target
+	for _, entry := range settings.UserList {
+		if !entry.IsEnabled() {
+			continue
+		}
+		entry = settings.ForUser(entry)
+		interval, err := time.ParseDuration(entry.Interval)
+		if err != nil || interval <= 0 {
+			interval = time.Hour
+		}
+		entries[entry.User] = entry
+		targets = append(targets, watch.Target{Name: entry.User, Interval: interval})
+	}
+	jitter, _ := time.ParseDuration(settings.Watch.Jitter)
+
+	stopProgress := startRun()
+	defer stopProgress()
+
+	w := watch.Watcher{
+		Jitter:    jitter,
+		StatePath: filepath.Join(settings.OutputDir, "watch.json"),
+		Poll: func(ctx context.Context, target watch.Target) error {
+			return downloadByUser(ctx, entries[target.Name])
+		},
+	}
+	err := w.Run(ctx, targets)
+	slog.Info("watch stopped, saving download record")
+	if saveErr := ledger.SaveToFile(); saveErr != nil {
+		slog.Error("save download record", "err", saveErr)
+	}
+	return err
+}
@@ -4,6 +4,7 @@ go 1.22.5
 
 require (
 	github.com/gocolly/colly v1.2.0
+	github.com/klauspost/compress v1.18.0
 	github.com/tidwall/gjson v1.17.1
 )
 
@@ -0,0 +1,284 @@
+package archive
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"twitterDownload/pkg/download"
+	"twitterDownload/pkg/storage"
+	"twitterDownload/pkg/user"
+)
+
+// DefaultPartAge is how long a .part file must be left untouched before GC
+// treats its download as abandoned
+const DefaultPartAge = time.Hour
+
+// GCOptions configures GC
+type GCOptions struct {
+	Ledger storage.URLStorage
+	// Dirs are the user folders whose sidecars are pruned, whose unfinished
+	// downloads are removed and whose disk use is reported
+	Dirs []string
+	// PartAge keeps .part files modified more recently, which may still be
+	// written to, DefaultPartAge when zero
+	PartAge time.Duration
+	// DryRun only reports what would be removed
+	DryRun bool
+}
+
+// Missing is a ledger entry whose local file is gone
+type Missing struct {
+	URL string
+	// Location is empty for entries recorded before locations were
+	Location string
+	// Item is the media item from the media.jsonl of Dir, nil when the
+	// file was saved before sidecars were written
+	Item *download.MediaItem
+	Dir  string
+}
+
+// Usage is the disk space taken by one user folder
+type Usage struct {
+	Dir   string
+	User  string
+	Files int
+	Bytes int64
+}
+
+// GCResult is what GC found and removed
+type GCResult struct {
+	// Checked counts ledger entries with a local file, Unchecked those
+	// stored remotely or in a bundle, and those without a location when
+	// there are no Dirs to look in
+	Checked   int
+	Unchecked int
+	// Missing entries were removed from the ledger and their sidecars
+	Missing   []Missing
+	Parts     []string
+	PartBytes int64
+	Usage     []Usage
+}
+
+// sidecarItem is a media item together with the folder of its sidecar
+type sidecarItem struct {
+	item download.MediaItem
+	dir  string
+}
+
+// GC cross-checks the ledger against the disk. Entries whose local file is
+// gone are removed from the ledger and from the media.jsonl sidecars of
+// opts.Dirs, so the media can be downloaded again, and abandoned .part
+// files of the downloader in opts.Dirs are deleted. Nothing is changed on a
+// dry run.
+//
+// Entries recorded before locations were have no path to check; they count
+// as present when a file named after the URL, whatever its extension, is in
+// one of opts.Dirs, so files moved out of them are treated as deleted.
+func GC(ctx context.Context, opts GCOptions) (GCResult, error) {
+	var result GCResult
+
+	items := make(map[string]sidecarItem)
+	for _, dir := range indexDirs(opts.Dirs) {
+		entries, err := download.ReadIndex(dir)
+		if err != nil {
+			return result, err
+		}
+		kept := entries[:0]
+		for _, entry := range entries {
+			items[download.EventURL(entry.MediaItem)] = sidecarItem{item: entry.MediaItem, dir: dir}
+			if missing(entry.Location) {
+				continue
+			}
+			if missing(entry.Thumb) {
+				entry.Thumb = ""
+			}
+			kept = append(kept, entry)
+		}
+		if !opts.DryRun && len(kept) < len(entries) {
+			if err := download.WriteIndex(dir, kept); err != nil {
+				return result, err
+			}
+		}
+	}
+
+	entries := opts.Ledger.Entries()
+	urls := make([]string, 0, len(entries))
+	for url := range entries {
+		urls = append(urls, url)
+	}
+	sort.Strings(urls)
+	var names map[string]bool
+	for _, url := range urls {
+		location := entries[url]
+		switch {
+		case location == "" && len(opts.Dirs) > 0:
+			if names == nil {
+				var err error
+				if names, err = fileNames(opts.Dirs); err != nil {
+					return result, err
+				}
+			}
+			result.Checked++
+			if names[download.DefaultName(url)] {
+				continue
+			}
+		case download.IsLocalLocation(location):
+			result.Checked++
+			if !missing(location) {
+				continue
+			}
+		default:
+			result.Unchecked++
+			continue
+		}
+		m := Missing{URL: url, Location: location}
+		if known, ok := items[url]; ok && !download.IsThumbKey(url) {
+			m.Item, m.Dir = &known.item, known.dir
+		}
+		result.Missing = append(result.Missing, m)
+		if !opts.DryRun {
+			if err := opts.Ledger.RemoveURL(url); err != nil {
+				return result, err
+			}
+		}
+	}
+
+	partAge := opts.PartAge
+	if partAge <= 0 {
+		partAge = DefaultPartAge
+	}
+	for _, dir := range opts.Dirs {
+		if ctx.Err() != nil {
+			return result, ctx.Err()
+		}
+		if err := removeParts(dir, time.Now().Add(-partAge), opts.DryRun, &result); err != nil {
+			return result, err
+		}
+		usage, err := diskUsage(dir)
+		if err != nil {
+			return result, err
+		}
+		result.Usage = append(result.Usage, usage)
+	}
+	return result, nil
+}
+
+// indexDirs are the folders of dirs that may hold a media.jsonl
+func indexDirs(dirs []string) []string {
+	var out []string
+	for _, dir := range dirs {
+		out = append(out, dir, filepath.Join(dir, "likes"))
+	}
+	return out
+}
+
+// missing reports whether location is a local path that no longer exists
+func missing(location string) bool {
+	if !download.IsLocalLocation(location) {
+		return false
+	}
+	_, err := os.Stat(location)
+	return os.IsNotExist(err)
+}
+
+// fileNames collects the names without extension of the media files in
+// dirs, leaving out profiles, thumbnails and unfinished downloads
+func fileNames(dirs []string) (map[string]bool, error) {
+	names := make(map[string]bool)
+	for _, dir := range dirs {
+		err := walkUserDir(dir, func(path string, d fs.DirEntry) error {
+			if d.Type().IsRegular() && !strings.HasSuffix(d.Name(), ".part") {
+				names[strings.TrimSuffix(d.Name(), filepath.Ext(d.Name()))] = true
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return names, nil
+}
+
+// walkUserDir calls fn for the files of a user folder and its timeline
+// folders, skipping hidden folders and the profile
+func walkUserDir(dir string, fn func(path string, d fs.DirEntry) error) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if path != dir && (strings.HasPrefix(d.Name(), ".") || d.Name() == user.ProfileDirName) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		return fn(path, d)
+	})
+}
+
+// partName matches the unfinished files the downloader writes into user
+// folders: media, whose extension is only known once HLS streams are
+// complete, and sidecars
+var partName = regexp.MustCompile(`^[^.].*\.(?i:jpe?g|png|webp|gif|mp4|m4a|mov|jsonl)\.part$|^[^.][^.]*\.part$`)
+
+// removeParts deletes the .part files of a user folder and its thumbnails
+// that were last modified before cutoff
+func removeParts(dir string, cutoff time.Time, dryRun bool, result *GCResult) error {
+	for _, root := range []string{dir, filepath.Join(dir, download.ThumbDirName), filepath.Join(dir, "likes", download.ThumbDirName)} {
+		err := walkUserDir(root, func(path string, d fs.DirEntry) error {
+			if !d.Type().IsRegular() || !partName.MatchString(d.Name()) {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			if info.ModTime().After(cutoff) {
+				return nil
+			}
+			result.Parts = append(result.Parts, path)
+			result.PartBytes += info.Size()
+			if dryRun {
+				return nil
+			}
+			return os.Remove(path)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// diskUsage sums the files of a user folder
+func diskUsage(dir string) (Usage, error) {
+	usage := Usage{Dir: dir, User: filepath.Base(dir)}
+	if snapshot, ok, _ := user.LoadLatestSnapshot(dir); ok && snapshot.UserName != "" {
+		usage.User = snapshot.UserName
+	}
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		usage.Files++
+		usage.Bytes += info.Size()
+		return nil
+	})
+	return usage, err
+}
@@ -0,0 +1,134 @@
+package archive
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"twitterDownload/pkg/storage"
+)
+
+func writeFile(t *testing.T, path string, data string, modTime time.Time) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGCLegacyLedger(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "123")
+	writeFile(t, filepath.Join(dir, "GMAXMobaYAAk3Ab.jpg"), "kept", time.Now())
+	writeFile(t, filepath.Join(dir, "likes", "GLikedPhotoAAAA.png"), "liked, saved as png", time.Now())
+
+	// a log.json written before locations were recorded
+	ledgerPath := filepath.Join(root, "log.json")
+	writeFile(t, ledgerPath, `{
+		"https://pbs.twimg.com/media/GMAXMobaYAAk3Ab.jpg": true,
+		"https://pbs.twimg.com/media/GLikedPhotoAAAA.jpg": true,
+		"https://pbs.twimg.com/media/GDeletedPhotoAA.jpg": true,
+		"https://video.twimg.com/ext_tw_video/1/pu/vid/720x1280/deletedVideoName.mp4": true,
+		"https://pbs.twimg.com/media/GBundledPhotoAA.jpg": "123.zip!123/GBundledPhotoAA.jpg"
+	}`, time.Now())
+	ledger, err := storage.OpenURLStore(ledgerPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := GC(context.Background(), GCOptions{Ledger: ledger, Dirs: []string{dir}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Checked != 4 || result.Unchecked != 1 {
+		t.Errorf("checked %d, unchecked %d, want 4 and 1", result.Checked, result.Unchecked)
+	}
+	var urls []string
+	for _, m := range result.Missing {
+		urls = append(urls, m.URL)
+	}
+	want := []string{
+		"https://pbs.twimg.com/media/GDeletedPhotoAA.jpg",
+		"https://video.twimg.com/ext_tw_video/1/pu/vid/720x1280/deletedVideoName.mp4",
+	}
+	if !reflect.DeepEqual(urls, want) {
+		t.Errorf("missing %v, want %v", urls, want)
+	}
+	for _, url := range want {
+		if ledger.URLExists(url) {
+			t.Errorf("%s still in the ledger", url)
+		}
+	}
+	if !ledger.URLExists("https://pbs.twimg.com/media/GLikedPhotoAAAA.jpg") {
+		t.Error("liked photo saved in another format was removed")
+	}
+}
+
+func TestGCLegacyLedgerWithoutDirs(t *testing.T) {
+	ledger := storage.NewURLStore("")
+	ledger.AddURL("https://pbs.twimg.com/media/GMAXMobaYAAk3Ab.jpg")
+
+	result, err := GC(context.Background(), GCOptions{Ledger: ledger})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Missing) != 0 || result.Unchecked != 1 || !ledger.URLExists("https://pbs.twimg.com/media/GMAXMobaYAAk3Ab.jpg") {
+		t.Errorf("entries were pruned with no folder to look in: %+v", result)
+	}
+}
+
+func TestGCRemovesOnlyAbandonedParts(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "123")
+	old := time.Now().Add(-2 * DefaultPartAge)
+
+	abandoned := []string{
+		filepath.Join(dir, "GMAXMobaYAAk3Ab.jpg.part"),
+		filepath.Join(dir, "1788457410342916234_1.part"),
+		filepath.Join(dir, "likes", "media.jsonl.part"),
+		filepath.Join(dir, ".thumbs", "GMAXMobaYAAk3Ab.jpg.part"),
+	}
+	kept := []string{
+		// still being written
+		filepath.Join(dir, "GNewDownloadAAA.mp4.part"),
+		// not written by the downloader
+		filepath.Join(dir, "notes.txt.part"),
+		filepath.Join(dir, ".hidden", "video.mp4.part"),
+		filepath.Join(dir, "profile", "banner.jpg.part"),
+		filepath.Join(root, "other", "project.mp4.part"),
+		filepath.Join(root, "123.zip.part"),
+	}
+	for _, path := range abandoned {
+		writeFile(t, path, "part", old)
+	}
+	for _, path := range kept[1:] {
+		writeFile(t, path, "part", old)
+	}
+	writeFile(t, kept[0], "part", time.Now())
+
+	result, err := GC(context.Background(), GCOptions{Ledger: storage.NewURLStore(""), Dirs: []string{dir}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Parts) != len(abandoned) || result.PartBytes != int64(4*len(abandoned)) {
+		t.Errorf("removed %v (%d bytes), want %v", result.Parts, result.PartBytes, abandoned)
+	}
+	for _, path := range abandoned {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("%s was not removed", path)
+		}
+	}
+	for _, path := range kept {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("%s was removed", path)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package archive
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+
+	"twitterDownload/pkg/download"
+	"twitterDownload/pkg/storage"
+	"twitterDownload/pkg/utils"
+)
+
+// Resolver returns the media of a tweet, e.g. by asking the API
+type Resolver func(ctx context.Context, tweetId string) ([]download.MediaItem, error)
+
+// Options configures Import
+type Options struct {
+	// Ledger receives the imported files. It is not saved.
+	Ledger storage.URLStorage
+	// Resolve looks up the tweets of TweetIndex files, which stay
+	// unresolved when it is nil
+	Resolve Resolver
+	// DryRun only reports what would be imported
+	DryRun bool
+}
+
+// Unresolved is a recognised file whose media URL is unknown
+type Unresolved struct {
+	Path   string
+	Reason string
+}
+
+// Result is what Import found
+type Result struct {
+	// Files counts recognised media files
+	Files int
+	// Imported files were added to the ledger, Known ones were in it
+	Imported int
+	Known    int
+	// Bytes is the size of the imported files
+	Bytes      int64
+	Unresolved []Unresolved
+	// Duplicates are groups of recognised files with the same content
+	Duplicates [][]string
+	Orphans    []string
+}
+
+// Import scans root, hashes every recognised media file and records the
+// ones whose media URL is known in the ledger with their path, so crawls
+// skip them. Each folder also gets media.jsonl entries for its imported
+// files, carrying the hash. Nothing is written on a dry run.
+func Import(ctx context.Context, root string, opts Options) (Result, error) {
+	files, orphans, err := Scan(root)
+	result := Result{Files: len(files), Orphans: orphans}
+	if err != nil {
+		return result, err
+	}
+
+	tweets := make(map[string][]download.MediaItem)
+	tweetErrs := make(map[string]error)
+	byHash := make(map[string][]string)
+	var hashes []string
+	entries := make(map[string][]download.IndexEntry)
+
+	for _, file := range files {
+		if ctx.Err() != nil {
+			return result, ctx.Err()
+		}
+		hash, err := utils.HashFile(file.Path)
+		if err != nil {
+			return result, err
+		}
+		if len(byHash[hash]) == 0 {
+			hashes = append(hashes, hash)
+		}
+		byHash[hash] = append(byHash[hash], file.Path)
+
+		item, reason := resolve(ctx, file.Match, opts.Resolve, tweets, tweetErrs)
+		if ctx.Err() != nil {
+			return result, ctx.Err()
+		}
+		if reason != "" {
+			result.Unresolved = append(result.Unresolved, Unresolved{Path: file.Path, Reason: reason})
+			continue
+		}
+		key := download.EventURL(item)
+		if opts.Ledger.URLExists(key) {
+			result.Known++
+			continue
+		}
+		result.Imported++
+		result.Bytes += file.Size
+		if opts.DryRun {
+			continue
+		}
+		opts.Ledger.AddLocation(key, file.Path)
+		dir := filepath.Dir(file.Path)
+		entries[dir] = append(entries[dir], download.IndexEntry{
+			MediaItem: item,
+			Location:  file.Path,
+			Bytes:     file.Size,
+			Saved:     file.ModTime,
+			SHA256:    hash,
+		})
+	}
+
+	for _, hash := range hashes {
+		if paths := byHash[hash]; len(paths) > 1 {
+			result.Duplicates = append(result.Duplicates, paths)
+		}
+	}
+	dirs := make([]string, 0, len(entries))
+	for dir := range entries {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+	for _, dir := range dirs {
+		if err := download.AppendIndex(dir, entries[dir]...); err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// resolve finds the media item a file holds, or says why it cannot. Tweets
+// are looked up once and remembered in tweets and errs.
+func resolve(ctx context.Context, m Match, lookup Resolver, tweets map[string][]download.MediaItem, errs map[string]error) (download.MediaItem, string) {
+	switch m.Kind {
+	case MediaID:
+		return download.MediaItem{Type: "photo", MediaURL: m.URL(), URL: m.URL()}, ""
+	case VideoName:
+		return download.MediaItem{}, "a video URL cannot be rebuilt from its file name, rename it to <tweet id>_<n>.mp4"
+	}
+	if lookup == nil {
+		return download.MediaItem{}, "tweet " + m.TweetId + " not looked up"
+	}
+	if _, looked := tweets[m.TweetId]; !looked {
+		items, err := lookup(ctx, m.TweetId)
+		tweets[m.TweetId], errs[m.TweetId] = items, err
+	}
+	if err := errs[m.TweetId]; err != nil {
+		return download.MediaItem{}, err.Error()
+	}
+	for _, item := range tweets[m.TweetId] {
+		if item.Index == m.Index {
+			return item, ""
+		}
+	}
+	return download.MediaItem{}, fmt.Sprintf("tweet %s has no media %d", m.TweetId, m.Index)
+}
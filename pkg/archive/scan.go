@@ -0,0 +1,122 @@
+// Package archive keeps the download record in step with the files on
+// disk: it brings media folders saved by older runs or other tools into the
+// record, so crawls skip what is already there, and prunes the records of
+// files that were deleted.
+package archive
+
+import (
+	"io/fs"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"twitterDownload/pkg/user"
+)
+
+// Kind tells how a file name was recognised
+type Kind string
+
+const (
+	// MediaID names are the ID of a photo on pbs.twimg.com, e.g.
+	// GMAXMobaYAAk3Ab.jpg
+	MediaID Kind = "media-id"
+	// TweetIndex names end in <tweet id>_<n>, the n-th media of a tweet,
+	// e.g. 1788457410342916234_1.jpg or jack_1788457410342916234_2.mp4
+	TweetIndex Kind = "tweet-index"
+	// VideoName names are the last part of a video.twimg.com URL, which
+	// cannot be rebuilt from them
+	VideoName Kind = "video-name"
+)
+
+// Match is what a recognised file name says about its media
+type Match struct {
+	Kind    Kind
+	MediaID string
+	TweetId string
+	Index   int
+	// Ext is the lower case extension without the dot
+	Ext string
+}
+
+var (
+	tweetIndexName = regexp.MustCompile(`(?:^|[^0-9])([0-9]{15,20})[_-]([0-9]{1,2})\.(?i:(jpe?g|png|webp|gif|mp4|m4a))$`)
+	mediaIDName    = regexp.MustCompile(`^([A-Za-z0-9_-]{15})\.(?i:(jpe?g|png|webp|gif))$`)
+	videoName      = regexp.MustCompile(`^[A-Za-z0-9_-]{16}\.(?i:mp4)$`)
+)
+
+// Recognize matches a file name against the known media name patterns
+func Recognize(name string) (Match, bool) {
+	if m := tweetIndexName.FindStringSubmatch(name); m != nil {
+		index, _ := strconv.Atoi(m[2])
+		return Match{Kind: TweetIndex, TweetId: m[1], Index: index, Ext: strings.ToLower(m[3])}, index > 0
+	}
+	if m := mediaIDName.FindStringSubmatch(name); m != nil {
+		return Match{Kind: MediaID, MediaID: m[1], Ext: strings.ToLower(m[2])}, true
+	}
+	if videoName.MatchString(name) {
+		return Match{Kind: VideoName, Ext: "mp4"}, true
+	}
+	return Match{}, false
+}
+
+// URL is the image URL of a MediaID match, the key it is recorded under.
+// The downloader records an image under the tweet's media_url whatever
+// format the saved original has, so a png or webp file, fetched through
+// ImageFormats, maps to the jpg URL that media_url almost always is.
+func (m Match) URL() string {
+	ext := m.Ext
+	switch ext {
+	case "jpeg", "png", "webp":
+		ext = "jpg"
+	}
+	return "https://pbs.twimg.com/media/" + m.MediaID + "." + ext
+}
+
+// File is a recognised media file
+type File struct {
+	Path    string
+	Size    int64
+	ModTime time.Time
+	Match   Match
+}
+
+// ownFiles are written by the downloader next to the media
+var ownFiles = regexp.MustCompile(`^(log\.json|record\.csv|media\.jsonl|sync\.json|watch\.json|manifest\.json|index\.html|setting\.json|run-.*\.json|export\..*|.*\.part|[0-9]+\.(zip|tar|tar\.gz|tar\.zst))$`)
+
+// Scan walks root and returns its recognised media files and the orphans,
+// files that match no pattern. What the downloader writes itself, the
+// ledger, sidecars, CSV, profile folders, thumbnails and unfinished
+// downloads, is neither, and neither are hidden files.
+func Scan(root string) ([]File, []string, error) {
+	var files []File
+	var orphans []string
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		name := d.Name()
+		if d.IsDir() {
+			if path != root && (strings.HasPrefix(name, ".") || name == user.ProfileDirName) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() || strings.HasPrefix(name, ".") || ownFiles.MatchString(name) {
+			return nil
+		}
+		match, ok := Recognize(name)
+		if !ok {
+			orphans = append(orphans, path)
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		files = append(files, File{Path: path, Size: info.Size(), ModTime: info.ModTime(), Match: match})
+		return nil
+	})
+	return files, orphans, err
+}
@@ -0,0 +1,27 @@
+package archive
+
+import "testing"
+
+func TestMatchURL(t *testing.T) {
+	tests := []struct {
+		name string
+		url  string
+	}{
+		{"GMAXMobaYAAk3Ab.jpg", "https://pbs.twimg.com/media/GMAXMobaYAAk3Ab.jpg"},
+		{"GMAXMobaYAAk3Ab.JPEG", "https://pbs.twimg.com/media/GMAXMobaYAAk3Ab.jpg"},
+		// originals fetched in another format are recorded under media_url
+		{"GMAXMobaYAAk3Ab.png", "https://pbs.twimg.com/media/GMAXMobaYAAk3Ab.jpg"},
+		{"GMAXMobaYAAk3Ab.webp", "https://pbs.twimg.com/media/GMAXMobaYAAk3Ab.jpg"},
+		{"GMAXMobaYAAk3Ab.gif", "https://pbs.twimg.com/media/GMAXMobaYAAk3Ab.gif"},
+	}
+	for _, tt := range tests {
+		m, ok := Recognize(tt.name)
+		if !ok || m.Kind != MediaID {
+			t.Errorf("%s recognised as %+v, %v", tt.name, m, ok)
+			continue
+		}
+		if got := m.URL(); got != tt.url {
+			t.Errorf("%s: URL %s, want %s", tt.name, got, tt.url)
+		}
+	}
+}
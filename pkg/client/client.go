@@ -0,0 +1,366 @@
+// Package client embeds the downloader in other programs. A Client holds
+// everything a download needs, credentials, HTTP client, output folder,
+// ledger and event sink, so several clients can run side by side without
+// sharing state.
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+
+	"twitterDownload/pkg/collector"
+	"twitterDownload/pkg/config"
+	"twitterDownload/pkg/download"
+	"twitterDownload/pkg/progress"
+	"twitterDownload/pkg/report"
+	"twitterDownload/pkg/sink"
+	"twitterDownload/pkg/storage"
+	"twitterDownload/pkg/thumb"
+	"twitterDownload/pkg/user"
+	"twitterDownload/pkg/utils"
+)
+
+// Options configures a Client. Every field is optional.
+type Options struct {
+	// Cookie is the cookie header of a logged-in session, including ct0.
+	// Methods calling the API need it, MakeThumbnails and downloading
+	// already known media do not.
+	Cookie string
+	// HTTPClient sends every request, http.DefaultClient when nil
+	HTTPClient *http.Client
+	// APIBase is the API server, https://twitter.com when empty
+	APIBase string
+	// Concurrency bounds parallel requests, the number of CPUs when zero
+	Concurrency int
+
+	// OutputDir holds one folder per user, the working directory when empty
+	OutputDir string
+	// Ledger records downloaded media so they are not fetched again. It is
+	// kept in memory when nil.
+	Ledger storage.URLStorage
+	// Sink stores media and profile images below OutputDir, the local disk
+	// when nil. Profile snapshots, sync state, the ledger and the CSV stay
+	// on the local disk.
+	Sink sink.Sink
+	// RecordCSV is a file every downloaded user's media rows are appended
+	// to, none when empty
+	RecordCSV string
+	// NameTemplate names saved files, config.DefaultNameTemplate when empty
+	NameTemplate string
+	// ImageFormats are extra original image formats tried first
+	ImageFormats []string
+	// Thumbnails is the longest edge in pixels of the thumbnails made next
+	// to every downloaded media item, none are made when zero
+	Thumbnails int
+
+	// SyncMode, KnownPages, Filters and Sources control timeline crawls as
+	// described on download.Run
+	SyncMode   download.SyncMode
+	KnownPages int
+	Filters    config.Filters
+	Sources    []download.Source
+	// DryRun plans downloads instead of making them, see download.Run
+	DryRun bool
+
+	// Events receives progress events of every call
+	Events progress.Sink
+	Hooks  Hooks
+}
+
+// Hooks are called around the work of DownloadUser
+type Hooks struct {
+	// BeforeUser runs once a user was looked up and before anything is
+	// downloaded. Returning an error skips the user.
+	BeforeUser func(ctx context.Context, u user.UserInfo) error
+	// AfterUser runs when DownloadUser finished, also when it failed
+	AfterUser func(result UserResult)
+}
+
+// Client downloads users and tweets. It is safe for concurrent use.
+type Client struct {
+	opts Options
+	api  collector.API
+}
+
+// New creates a Client, filling in the defaults opts leaves out
+func New(opts Options) (*Client, error) {
+	if opts.OutputDir == "" {
+		opts.OutputDir = "."
+	}
+	if opts.Ledger == nil {
+		opts.Ledger = storage.NewURLStore("")
+	}
+	return &Client{
+		opts: opts,
+		api: collector.API{
+			Cookie:      opts.Cookie,
+			Base:        opts.APIBase,
+			Concurrency: opts.Concurrency,
+			Client:      opts.HTTPClient,
+		},
+	}, nil
+}
+
+// Close finishes the sink when it needs closing, e.g. a bundle, and saves
+// the ledger unless this is a dry run. Media of archives that could not be
+// finished are dropped from the ledger first, so the next run fetches them
+// again.
+func (c *Client) Close() error {
+	var err error
+	if closer, ok := c.opts.Sink.(io.Closer); ok {
+		err = closer.Close()
+	}
+	if c.opts.DryRun {
+		return err
+	}
+	var commitErr *sink.CommitError
+	if errors.As(err, &commitErr) {
+		lost := make(map[string]bool, len(commitErr.Lost))
+		for _, location := range commitErr.Lost {
+			lost[location] = true
+		}
+		for url, location := range c.opts.Ledger.Entries() {
+			if lost[location] {
+				c.opts.Ledger.RemoveURL(url)
+			}
+		}
+	}
+	return errors.Join(err, c.opts.Ledger.SaveToFile())
+}
+
+// newRun creates the run of one call, reporting its events to sinks as
+// well as to Options.Events
+func (c *Client) newRun(sinks ...progress.Sink) *download.Run {
+	return &download.Run{
+		Report:       report.NewReport(),
+		Events:       append(progress.Multi{c.opts.Events}, sinks...),
+		API:          c.api,
+		Ledger:       c.opts.Ledger,
+		Sink:         c.opts.Sink,
+		ImageFormats: c.opts.ImageFormats,
+		NameTemplate: c.opts.NameTemplate,
+		RecordCSV:    c.opts.RecordCSV,
+		SyncMode:     c.opts.SyncMode,
+		KnownPages:   c.opts.KnownPages,
+		Filters:      c.opts.Filters,
+		Sources:      c.opts.Sources,
+		DryRun:       c.opts.DryRun,
+		Thumbnails:   c.opts.Thumbnails,
+	}
+}
+
+// FetchUser looks a user up by screen name or by "id:<user id>". Missing,
+// suspended, protected and unavailable accounts are reported as the
+// matching user.Err* errors.
+func (c *Client) FetchUser(ctx context.Context, ref string) (user.UserInfo, error) {
+	return user.FetchUser(ctx, c.api, ref)
+}
+
+// SkipSource is returned by the fn of IterateUserMedia to leave the rest of
+// the current timeline and go on with the next one. IterateUserMedia itself
+// never returns it.
+var SkipSource = errors.New("skip the rest of the timeline")
+
+// IterateUserMedia calls fn for every media item on the timelines of u
+// selected by Options.Sources, newest first, without downloading. It stops
+// at the first error returned by fn other than SkipSource.
+func (c *Client) IterateUserMedia(ctx context.Context, u user.UserInfo, fn func(download.MediaItem) error) error {
+	sources := c.opts.Sources
+	if len(sources) == 0 {
+		sources = []download.Source{download.SourceMedia}
+	}
+	for _, source := range sources {
+		if err := download.IterateMedia(ctx, c.api, source, u, fn); err != nil && !errors.Is(err, SkipSource) {
+			return err
+		}
+	}
+	return nil
+}
+
+// StreamUserMedia crawls one timeline of u in the background and yields its
+// media items on the returned stream, resuming at cursor when it is not
+// empty
+func (c *Client) StreamUserMedia(ctx context.Context, u user.UserInfo, source download.Source, cursor string) *download.MediaStream {
+	return download.StreamMedia(ctx, c.api, source, u, cursor)
+}
+
+// MediaStatus is the outcome of one media item
+type MediaStatus string
+
+const (
+	MediaDone    MediaStatus = "done"
+	MediaSkipped MediaStatus = "skipped"
+	MediaFailed  MediaStatus = "failed"
+	// MediaPlanned is an item a dry run would download
+	MediaPlanned MediaStatus = "planned"
+)
+
+// MediaResult is what happened to one media item
+type MediaResult struct {
+	Item   download.MediaItem
+	Status MediaStatus
+	// Reason says why an item was skipped
+	Reason string
+	// Bytes is the size of a saved item, or the estimated size of a planned
+	// one
+	Bytes int64
+	// Path is where a planned item would be saved
+	Path string
+	Err  error
+}
+
+// TweetResult is the outcome of DownloadTweet
+type TweetResult struct {
+	TweetId string
+	Author  user.UserInfo
+	Media   []MediaResult
+}
+
+// UserResult is the outcome of DownloadUser
+type UserResult struct {
+	User user.UserInfo
+	// Stats counts pages, new, skipped and failed media and bytes
+	Stats  progress.Stats
+	Errors []*report.Error
+}
+
+// Failed reports whether anything of the user failed
+func (r UserResult) Failed() bool {
+	return len(r.Errors) > 0 || r.Stats.Failed > 0
+}
+
+// outcomes collects the final event of every media item by URL
+type outcomes struct {
+	mu     sync.Mutex
+	events map[string]progress.Event
+}
+
+func (o *outcomes) Emit(e progress.Event) {
+	switch e.Kind {
+	case progress.MediaDone, progress.MediaSkipped, progress.MediaFailed, progress.MediaPlanned:
+		o.mu.Lock()
+		o.events[e.URL] = e
+		o.mu.Unlock()
+	}
+}
+
+// prepareSaveDir points u.SaveDir at the user's folder, only moving legacy
+// folders when files are actually going to be written
+func (c *Client) prepareSaveDir(u *user.UserInfo) error {
+	if c.opts.DryRun {
+		u.SaveDir = user.SaveDir(*u, c.opts.OutputDir)
+		return nil
+	}
+	return user.PrepareSaveDir(u, c.opts.OutputDir)
+}
+
+// FetchTweet looks a tweet up and returns its author and media without
+// downloading anything
+func (c *Client) FetchTweet(ctx context.Context, tweetId string) (user.UserInfo, []download.MediaItem, error) {
+	return download.FetchTweet(ctx, c.api, tweetId)
+}
+
+// DownloadTweet downloads the media of one tweet into its author's folder
+func (c *Client) DownloadTweet(ctx context.Context, tweetId string) (TweetResult, error) {
+	author, items, err := download.FetchTweet(ctx, c.api, tweetId)
+	result := TweetResult{TweetId: tweetId, Author: author}
+	if err != nil {
+		return result, err
+	}
+	if err := c.prepareSaveDir(&author); err != nil {
+		return result, err
+	}
+	result.Author = author
+	result.Media, err = c.DownloadItems(ctx, author, items)
+	return result, err
+}
+
+// DownloadItems downloads media items into u.SaveDir, e.g. ones listed by
+// IterateUserMedia or files lost from the archive. Items in the ledger are
+// skipped.
+func (c *Client) DownloadItems(ctx context.Context, u user.UserInfo, items []download.MediaItem) ([]MediaResult, error) {
+	seen := &outcomes{events: make(map[string]progress.Event)}
+	run := c.newRun(seen)
+	download.DownloadItems(ctx, run, items, &u)
+
+	results := make([]MediaResult, 0, len(items))
+	for _, item := range items {
+		media := MediaResult{Item: item, Status: MediaSkipped, Reason: "interrupted"}
+		if e, ok := seen.events[download.EventURL(item)]; ok {
+			media.Reason, media.Bytes, media.Path, media.Err = e.Reason, e.Bytes, e.Path, e.Err
+			switch e.Kind {
+			case progress.MediaDone:
+				media.Status = MediaDone
+			case progress.MediaPlanned:
+				media.Status = MediaPlanned
+			case progress.MediaFailed:
+				media.Status = MediaFailed
+			}
+		}
+		results = append(results, media)
+	}
+	if errs := run.Report.Errors(); len(errs) > 0 {
+		return results, errs[0]
+	}
+	return results, ctx.Err()
+}
+
+// DownloadUser archives the profile of a user and downloads the media of
+// its timelines into a folder named after the user ID
+func (c *Client) DownloadUser(ctx context.Context, ref string) (UserResult, error) {
+	tracker := progress.NewTracker()
+	run := c.newRun(tracker)
+	result, err := c.downloadUser(ctx, run, ref)
+	for _, stats := range tracker.Snapshot().Users {
+		if stats.User == result.User.UserName {
+			result.Stats = stats
+		}
+	}
+	result.Errors = run.Report.Errors()
+	if c.opts.Hooks.AfterUser != nil {
+		c.opts.Hooks.AfterUser(result)
+	}
+	return result, err
+}
+
+func (c *Client) downloadUser(ctx context.Context, run *download.Run, ref string) (UserResult, error) {
+	u, err := user.FetchUser(ctx, c.api, ref)
+	result := UserResult{User: u}
+	if errors.Is(err, context.Canceled) {
+		return result, err
+	}
+	if err != nil {
+		run.Report.Add(report.Wrap(err, report.StageUser, ref, "", ""))
+		return result, err
+	}
+	if c.opts.Hooks.BeforeUser != nil {
+		if err := c.opts.Hooks.BeforeUser(ctx, u); err != nil {
+			return result, err
+		}
+	}
+
+	if err := c.prepareSaveDir(&u); err != nil {
+		slog.Warn("move legacy archive folder", "user", u.UserName, "err", err)
+	}
+	result.User = u
+	download.DownloadProfile(ctx, run, &u)
+	csvList := []utils.CSV{}
+	download.DownloadTwitterMedia(ctx, run, &u, &csvList)
+	return result, ctx.Err()
+}
+
+// MakeThumbnails adds the missing thumbnails of the media listed in the
+// media.jsonl index of dir, using Options.Thumbnails or thumb.DefaultSize
+// when that is zero. It returns how many were made and what failed.
+func (c *Client) MakeThumbnails(ctx context.Context, dir string) (int, []*report.Error, error) {
+	run := c.newRun()
+	if run.Thumbnails <= 0 {
+		run.Thumbnails = thumb.DefaultSize
+	}
+	made, err := download.MakeThumbnails(ctx, run, dir)
+	return made, run.Report.Errors(), err
+}
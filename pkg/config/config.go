@@ -2,39 +2,469 @@ package config
 
 import (
 	"encoding/json"
-	"log"
+	"errors"
+	"fmt"
+	"net/url"
 	"os"
-
-	"twitterDownload/pkg/storage"
+	"strconv"
+	"strings"
+	"time"
 )
 
+// DefaultPath is the settings file read when no path is given
+const DefaultPath = "setting.json"
+
+// EnvPrefix starts every environment variable that overrides a setting
+const EnvPrefix = "TWDL_"
+
+// DefaultNameTemplate keeps the file name the media URL ends with
+const DefaultNameTemplate = "{name}"
+
+// Filters 限定要下载的媒体
+type Filters struct {
+	// MediaTypes 只下载这些类型：photo、video、animated_gif，为空表示全部
+	MediaTypes []string `json:"mediaTypes"`
+	// Since 只下载该日期（2006-01-02）及之后的推文
+	Since string `json:"since"`
+}
+
+// AllowsMediaType 判断媒体类型是否在过滤列表中
+func (f Filters) AllowsMediaType(mediaType string) bool {
+	if len(f.MediaTypes) == 0 {
+		return true
+	}
+	for _, t := range f.MediaTypes {
+		if t == mediaType {
+			return true
+		}
+	}
+	return false
+}
+
+// StorageConfig 选择保存媒体文件的后端
+type StorageConfig struct {
+	// Backend 为 local、s3、webdav 或 bundle
+	Backend string       `json:"backend"`
+	S3      S3Config     `json:"s3"`
+	WebDAV  WebDAVConfig `json:"webdav"`
+	Bundle  BundleConfig `json:"bundle"`
+}
+
+// S3Config 是兼容 S3 的对象存储，对象键为 Prefix 加上相对于输出目录的路径
+type S3Config struct {
+	// Endpoint 如 https://s3.amazonaws.com 或 http://localhost:9000
+	Endpoint  string `json:"endpoint"`
+	Bucket    string `json:"bucket"`
+	Region    string `json:"region"`
+	Prefix    string `json:"prefix"`
+	AccessKey string `json:"accessKey"`
+	SecretKey string `json:"secretKey"`
+}
+
+// BundleConfig 把每个用户的文件写入输出目录下的一个归档 <用户ID>.<格式>
+type BundleConfig struct {
+	// Format 为 zip、tar、tar.gz 或 tar.zst，默认 zip
+	Format string `json:"format"`
+}
+
+// WebDAVConfig 是 WebDAV 服务器上保存文件的目录
+type WebDAVConfig struct {
+	URL      string `json:"url"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// ThumbnailConfig 控制下载后生成的 JPEG 缩略图，保存在媒体旁的 .thumbs 目录中
+type ThumbnailConfig struct {
+	Enabled bool `json:"enabled"`
+	// Size 缩略图最长边的像素数，0 表示默认的 320
+	Size int `json:"size"`
+}
+
+// WatchConfig 控制 watch 模式的轮询
+type WatchConfig struct {
+	// Interval 默认的轮询间隔，如 1h
+	Interval string `json:"interval"`
+	// Jitter 每次轮询额外等待的随机时长上限，避免所有用户同时请求
+	Jitter string `json:"jitter"`
+}
+
+// UserEntry 是 userList 中的一项，可以只写用户名字符串，也可以写成对象单独配置，
+// 未填写的字段使用全局配置
+type UserEntry struct {
+	// User 用户名或 id:<用户ID>
+	User string `json:"user"`
+	// OutputDir 该用户文件夹所在的目录
+	OutputDir  string   `json:"outputDir"`
+	MediaTypes []string `json:"mediaTypes"`
+	Since      string   `json:"since"`
+	// Sources 要爬取的时间线：media、likes，默认只有 media
+	Sources  []string `json:"sources"`
+	SyncMode string   `json:"syncMode"`
+	// Enabled 为 false 时跳过该用户
+	Enabled *bool `json:"enabled"`
+	// Interval watch 模式下轮询该用户的间隔，如 30m
+	Interval string `json:"interval"`
+}
+
+// UnmarshalJSON 同时接受字符串和对象两种写法
+func (e *UserEntry) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err == nil {
+		*e = UserEntry{User: name}
+		return nil
+	}
+	type plain UserEntry
+	return json.Unmarshal(data, (*plain)(e))
+}
+
+// IsEnabled 未填写 enabled 时默认启用
+func (e UserEntry) IsEnabled() bool {
+	return e.Enabled == nil || *e.Enabled
+}
+
+// Filters 返回该用户的过滤条件
+func (e UserEntry) Filters() Filters {
+	return Filters{MediaTypes: e.MediaTypes, Since: e.Since}
+}
+
 type Settings struct {
-	Cookie      string `json:"cookie"`
-	UserList	 	[]string `json:"userList"`
+	Cookie   string      `json:"cookie"`
+	UserList []UserEntry `json:"userList"`
+	// ImageFormats 额外尝试的原图格式（如 png、webp），优先于图片自身的格式
+	ImageFormats []string `json:"imageFormats"`
+	// SyncMode 同步模式：full、incremental（默认）或 backfill
+	SyncMode string `json:"syncMode"`
+	// KnownPages 增量同步时连续多少页没有新媒体即停止，0 表示默认值
+	KnownPages int `json:"knownPages"`
+	// OutputDir 存档根目录，用户目录、下载记录和运行总结都保存在这里
+	OutputDir string `json:"outputDir"`
+	// Concurrency 同时进行的请求数，0 表示 CPU 核数
+	Concurrency int     `json:"concurrency"`
+	Filters     Filters `json:"filters"`
+	// NameTemplate 文件名模板，可用 {name} {tweetId} {date} {user} {userId} {index}，扩展名自动添加
+	NameTemplate string          `json:"nameTemplate"`
+	Storage      StorageConfig   `json:"storage"`
+	Thumbnails   ThumbnailConfig `json:"thumbnails"`
+	Watch        WatchConfig     `json:"watch"`
+	// APIBase 接口地址，默认 https://twitter.com，可指向本地的模拟接口
+	APIBase string `json:"apiBase"`
+	// Profiles 命名配置，选中的配置覆盖上面的同名字段
+	Profiles map[string]json.RawMessage `json:"profiles,omitempty"`
 }
 
-var SettingConfig Settings
+// ForUser fills the fields an entry leaves out from the global settings
+func (s Settings) ForUser(entry UserEntry) UserEntry {
+	if entry.OutputDir == "" {
+		entry.OutputDir = s.OutputDir
+	}
+	if len(entry.MediaTypes) == 0 {
+		entry.MediaTypes = s.Filters.MediaTypes
+	}
+	if entry.Since == "" {
+		entry.Since = s.Filters.Since
+	}
+	if len(entry.Sources) == 0 {
+		entry.Sources = []string{"media"}
+	}
+	if entry.SyncMode == "" {
+		entry.SyncMode = s.SyncMode
+	}
+	if entry.Interval == "" {
+		entry.Interval = s.Watch.Interval
+	}
+	return entry
+}
 
-var LogRecord = storage.NewURLStore("log.json")
+// Options tells Load where to find the settings
+type Options struct {
+	// Path of the settings file, TWDL_CONFIG or DefaultPath when empty
+	Path string
+	// Profile selects an entry of "profiles", TWDL_PROFILE when empty
+	Profile string
+	// LookupEnv reads environment variables, os.LookupEnv when nil
+	LookupEnv func(string) (string, bool)
+}
 
-func init(){
-	LogRecord.LoadFromFile()
-	SettingConfig = LoadSettings("setting.json")
+// Default returns the settings used for fields the file leaves out
+func Default() Settings {
+	return Settings{
+		OutputDir:    ".",
+		NameTemplate: DefaultNameTemplate,
+		Storage:      StorageConfig{Backend: "local"},
+		Watch:        WatchConfig{Interval: "1h", Jitter: "5m"},
+		APIBase:      "https://twitter.com",
+	}
 }
 
-func LoadSettings(settingsFilePath string) Settings {
+// Load reads the settings file, applies the selected profile and the
+// TWDL_* environment variables and validates the result. A missing file is
+// only an error when its path was given explicitly, so a setup configured
+// purely through the environment works.
+func Load(opts Options) (Settings, error) {
+	lookup := opts.LookupEnv
+	if lookup == nil {
+		lookup = os.LookupEnv
+	}
+	path, explicit := opts.Path, opts.Path != ""
+	if !explicit {
+		if path, explicit = lookup(EnvPrefix + "CONFIG"); !explicit || path == "" {
+			path, explicit = DefaultPath, false
+		}
+	}
+	profile := opts.Profile
+	if profile == "" {
+		profile, _ = lookup(EnvPrefix + "PROFILE")
+	}
+
+	settings := Default()
+	data, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		if err := decode(data, &settings); err != nil {
+			return settings, fmt.Errorf("%s: %w", path, err)
+		}
+	case os.IsNotExist(err) && !explicit:
+	default:
+		return settings, fmt.Errorf("read settings: %w", err)
+	}
 
-	var settings Settings
+	if profile != "" {
+		raw, ok := settings.Profiles[profile]
+		if !ok {
+			return settings, fmt.Errorf("%s: profile %q not found", path, profile)
+		}
+		if err := decode(raw, &settings); err != nil {
+			return settings, fmt.Errorf("%s: profile %q: %w", path, profile, err)
+		}
+	}
 
-	data, err := os.ReadFile(settingsFilePath)
-	if err != nil {
-		log.Fatalf("Error reading settings file: %v", err)
+	if err := applyEnv(&settings, lookup); err != nil {
+		return settings, err
+	}
+	if err := settings.Validate(); err != nil {
+		return settings, fmt.Errorf("invalid settings: %w", err)
 	}
-	err = json.Unmarshal(data, &settings)
+	return settings, nil
+}
+
+// decode unmarshals over the fields already set, naming the offending field
+// when a value has the wrong type
+func decode(data []byte, settings *Settings) error {
+	err := json.Unmarshal(data, settings)
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		return fmt.Errorf("field %q: expected %s, got JSON %s", typeErr.Field, typeErr.Type, typeErr.Value)
+	}
+	return err
+}
+
+// applyEnv overrides settings with TWDL_COOKIE, TWDL_USERS (comma
+// separated), TWDL_OUTPUT_DIR, TWDL_CONCURRENCY, TWDL_SYNC_MODE,
+// TWDL_KNOWN_PAGES, TWDL_NAME_TEMPLATE, TWDL_STORAGE, TWDL_API_BASE and the
+// storage secrets TWDL_S3_ACCESS_KEY, TWDL_S3_SECRET_KEY and
+// TWDL_WEBDAV_PASSWORD
+func applyEnv(settings *Settings, lookup func(string) (string, bool)) error {
+	str := func(name string, field *string) {
+		if v, ok := lookup(EnvPrefix + name); ok {
+			*field = v
+		}
+	}
+	num := func(name string, field *int) error {
+		v, ok := lookup(EnvPrefix + name)
+		if !ok {
+			return nil
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil {
+			return fmt.Errorf("%s%s: %q is not a number", EnvPrefix, name, v)
+		}
+		*field = n
+		return nil
+	}
+
+	str("COOKIE", &settings.Cookie)
+	str("OUTPUT_DIR", &settings.OutputDir)
+	str("SYNC_MODE", &settings.SyncMode)
+	str("NAME_TEMPLATE", &settings.NameTemplate)
+	str("STORAGE", &settings.Storage.Backend)
+	str("API_BASE", &settings.APIBase)
+	str("S3_ACCESS_KEY", &settings.Storage.S3.AccessKey)
+	str("S3_SECRET_KEY", &settings.Storage.S3.SecretKey)
+	str("WEBDAV_PASSWORD", &settings.Storage.WebDAV.Password)
+	if v, ok := lookup(EnvPrefix + "USERS"); ok {
+		settings.UserList = nil
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				settings.UserList = append(settings.UserList, UserEntry{User: name})
+			}
+		}
+	}
+	return errors.Join(num("CONCURRENCY", &settings.Concurrency), num("KNOWN_PAGES", &settings.KnownPages))
+}
+
+var (
+	syncModes  = []string{"", "full", "incremental", "backfill"}
+	sources    = []string{"media", "likes"}
+	mediaTypes = []string{"photo", "video", "animated_gif"}
+	backends   = []string{"local", "s3", "webdav", "bundle"}
+	bundles    = []string{"", "zip", "tar", "tar.gz", "tar.zst"}
+	nameFields = []string{"name", "tweetId", "date", "user", "userId", "index"}
+)
+
+func oneOf(v string, allowed []string) bool {
+	for _, a := range allowed {
+		if v == a {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate reports every invalid field at once
+func (s Settings) Validate() error {
+	var errs []error
+	fail := func(field string, format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%s: %s", field, fmt.Sprintf(format, args...)))
+	}
+
+	for i, entry := range s.UserList {
+		field := fmt.Sprintf("userList[%d]", i)
+		if strings.TrimSpace(entry.User) == "" {
+			fail(field+".user", "is empty")
+		}
+		validateSyncMode(field+".syncMode", entry.SyncMode, fail)
+		validateDuration(field+".interval", entry.Interval, time.Second, fail)
+		validateFilters(field+".", entry.Filters(), fail)
+		for j, source := range entry.Sources {
+			if !oneOf(source, sources) {
+				fail(fmt.Sprintf("%s.sources[%d]", field, j), "%q is not one of %s", source, strings.Join(sources, ", "))
+			}
+		}
+	}
+	validateSyncMode("syncMode", s.SyncMode, fail)
+	if s.KnownPages < 0 {
+		fail("knownPages", "must not be negative")
+	}
+	if s.OutputDir == "" {
+		fail("outputDir", "is empty")
+	}
+	if s.Concurrency < 0 {
+		fail("concurrency", "must not be negative")
+	}
+	if s.Thumbnails.Size < 0 {
+		fail("thumbnails.size", "must not be negative")
+	}
+	validateFilters("filters.", s.Filters, fail)
+	validateDuration("watch.interval", s.Watch.Interval, time.Second, fail)
+	validateDuration("watch.jitter", s.Watch.Jitter, 0, fail)
+	if u, err := url.Parse(s.APIBase); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		fail("apiBase", "%q is not an http(s) URL", s.APIBase)
+	}
+	if err := validateTemplate(s.NameTemplate); err != nil {
+		fail("nameTemplate", "%v", err)
+	}
+	validateStorage(s.Storage, fail)
+	return errors.Join(errs...)
+}
+
+// ValidateCookie reports a cookie the API would refuse. Load does not check
+// it, so commands working only on the archive run without credentials.
+func (s Settings) ValidateCookie() error {
+	if strings.TrimSpace(s.Cookie) == "" {
+		return fmt.Errorf("cookie: is empty, copy it from a logged-in browser session or set %sCOOKIE", EnvPrefix)
+	}
+	if !strings.Contains(s.Cookie, "ct0=") {
+		return errors.New("cookie: has no ct0 field, copy the whole cookie header")
+	}
+	return nil
+}
+
+func validateStorage(s StorageConfig, fail func(string, string, ...any)) {
+	httpURL := func(field string, value string) {
+		if u, err := url.Parse(value); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			fail(field, "%q is not an http(s) URL", value)
+		}
+	}
+	switch s.Backend {
+	case "local":
+	case "s3":
+		httpURL("storage.s3.endpoint", s.S3.Endpoint)
+		if s.S3.Bucket == "" {
+			fail("storage.s3.bucket", "is empty")
+		}
+		if s.S3.AccessKey == "" || s.S3.SecretKey == "" {
+			fail("storage.s3", "accessKey and secretKey are required, or set %sS3_ACCESS_KEY and %sS3_SECRET_KEY", EnvPrefix, EnvPrefix)
+		}
+	case "webdav":
+		httpURL("storage.webdav.url", s.WebDAV.URL)
+	case "bundle":
+		if !oneOf(s.Bundle.Format, bundles) {
+			fail("storage.bundle.format", "%q is not zip, tar, tar.gz or tar.zst", s.Bundle.Format)
+		}
+	default:
+		fail("storage.backend", "%q is not supported, use %s", s.Backend, strings.Join(backends, ", "))
+	}
+}
+
+func validateSyncMode(field string, mode string, fail func(string, string, ...any)) {
+	if !oneOf(strings.ToLower(mode), syncModes) {
+		fail(field, "%q is not full, incremental or backfill", mode)
+	}
+}
+
+func validateDuration(field string, value string, min time.Duration, fail func(string, string, ...any)) {
+	if value == "" {
+		return
+	}
+	d, err := time.ParseDuration(value)
 	if err != nil {
-		log.Fatalf("Error parsing settings JSON: %v", err)
+		fail(field, "%q is not a duration like 30m or 2h", value)
+	} else if d < min {
+		fail(field, "%s is shorter than %s", d, min)
 	}
+}
 
-	return settings
+func validateFilters(prefix string, f Filters, fail func(string, string, ...any)) {
+	for i, t := range f.MediaTypes {
+		if !oneOf(t, mediaTypes) {
+			fail(fmt.Sprintf("%smediaTypes[%d]", prefix, i), "%q is not one of %s", t, strings.Join(mediaTypes, ", "))
+		}
+	}
+	if f.Since != "" {
+		if _, err := time.Parse("2006-01-02", f.Since); err != nil {
+			fail(prefix+"since", "%q is not a date like 2024-01-31", f.Since)
+		}
+	}
 }
 
+func validateTemplate(template string) error {
+	if strings.TrimSpace(template) == "" {
+		return errors.New("is empty")
+	}
+	if strings.ContainsAny(template, `/\`) {
+		return errors.New("must not contain path separators")
+	}
+	rest := template
+	for {
+		start := strings.Index(rest, "{")
+		if start < 0 {
+			break
+		}
+		end := strings.Index(rest[start:], "}")
+		if end < 0 {
+			return fmt.Errorf("unclosed %q", rest[start:])
+		}
+		if field := rest[start+1 : start+end]; !oneOf(field, nameFields) {
+			return fmt.Errorf("unknown field {%s}, use one of {%s}", field, strings.Join(nameFields, "} {"))
+		}
+		rest = rest[start+end+1:]
+	}
+	// a name must tell apart every media of the user: {index} alone repeats
+	// for each tweet, {tweetId} alone for each media of a tweet, and a file
+	// saved under an existing name overwrites it
+	if !strings.Contains(template, "{name}") && !(strings.Contains(template, "{tweetId}") && strings.Contains(template, "{index}")) {
+		return errors.New("must contain {name}, or both {tweetId} and {index}")
+	}
+	return nil
+}
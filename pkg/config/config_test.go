@@ -0,0 +1,73 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func noEnv(string) (string, bool) { return "", false }
+
+func TestLoadWithoutCookie(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "setting.json")
+	if err := os.WriteFile(path, []byte(`{"outputDir": "archive"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	settings, err := Load(Options{Path: path, LookupEnv: noEnv})
+	if err != nil {
+		t.Fatalf("settings without a cookie refused: %v", err)
+	}
+	if err := settings.ValidateCookie(); err == nil || !strings.Contains(err.Error(), "empty") {
+		t.Errorf("got %v, want the empty cookie reported", err)
+	}
+}
+
+func TestValidateCookie(t *testing.T) {
+	tests := []struct {
+		cookie string
+		ok     bool
+	}{
+		{"auth_token=abc; ct0=def", true},
+		{"ct0=def", true},
+		{"", false},
+		{"   ", false},
+		{"auth_token=abc", false},
+	}
+	for _, tt := range tests {
+		err := Settings{Cookie: tt.cookie}.ValidateCookie()
+		if (err == nil) != tt.ok {
+			t.Errorf("cookie %q: got %v", tt.cookie, err)
+		}
+	}
+}
+
+func TestValidateTemplate(t *testing.T) {
+	tests := []struct {
+		template string
+		err      string
+	}{
+		{"{name}", ""},
+		{"{date}_{tweetId}_{index}", ""},
+		{"{user}-{name}", ""},
+		{"", "empty"},
+		{"{tweetId}_{index}", ""},
+		{"{tweetId}", "must contain {name}, or both {tweetId} and {index}"},
+		{"{index}", "must contain {name}, or both {tweetId} and {index}"},
+		{"{date}_{index}", "must contain {name}, or both {tweetId} and {index}"},
+		{"{date}_{user}", "must contain {name}, or both {tweetId} and {index}"},
+		{"photo", "must contain {name}, or both {tweetId} and {index}"},
+		{"{user}/{name}", "path separators"},
+		{"{name}_{views}", "unknown field {views}"},
+		{"{name", "unclosed"},
+	}
+	for _, tt := range tests {
+		err := validateTemplate(tt.template)
+		switch {
+		case tt.err == "" && err != nil:
+			t.Errorf("%q refused: %v", tt.template, err)
+		case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+			t.Errorf("%q: got %v, want an error about %q", tt.template, err, tt.err)
+		}
+	}
+}
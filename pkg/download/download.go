@@ -1,16 +1,26 @@
 package download
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
-	"fmt"
-	"log"
+	"errors"
+	"log/slog"
 	"net/url"
+	"os"
+	"path"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"twitterDownload/pkg/collector"
 	"twitterDownload/pkg/config"
+	"twitterDownload/pkg/progress"
+	"twitterDownload/pkg/report"
+	"twitterDownload/pkg/sink"
+	"twitterDownload/pkg/storage"
 	"twitterDownload/pkg/user"
 	"twitterDownload/pkg/utils"
 
@@ -18,100 +28,223 @@ import (
 	"github.com/tidwall/gjson"
 )
 
-func downloadMedia(mediaUrls string, userInfo *user.UserInfo) {
-
-	extractSuffixAfterColon := func(url string) string {
-		suffix := ""
-		lastColonIndex := strings.LastIndex(url, ":")
+// mediaTask is one media URL to fetch together with what the API says it is
+type mediaTask struct {
+	URL         string
+	MediaType   string
+	ContentType string
+	TweetId     string
+	// Name is the file name without extension, from config.NameTemplate
+	Name string
+	// Item is the media item the task was made from, it goes to the
+	// media.jsonl sidecar once saved
+	Item MediaItem
+}
 
-		if !(lastColonIndex == -1) {
-			suffix = url[lastColonIndex:]
-		}
+// DefaultName is the last path element of a media URL without its
+// extension or :orig style suffix, the name files got before name templates
+func DefaultName(mediaUrl string) string {
+	base := path.Base(utils.TrimURLQueryAndHash(mediaUrl))
+	if i := strings.LastIndex(base, ":"); i != -1 {
+		base = base[:i]
+	}
+	return strings.TrimSuffix(base, path.Ext(base))
+}
 
-		return suffix
+// fileName expands run.NameTemplate for the n-th media of a tweet
+func fileName(run *Run, task mediaTask, index int, tweetDate string, userInfo *user.UserInfo) string {
+	template := run.NameTemplate
+	if template == "" {
+		template = config.DefaultNameTemplate
 	}
+	return utils.ExpandNameTemplate(template, map[string]string{
+		"name":    DefaultName(task.URL),
+		"tweetId": task.TweetId,
+		"date":    tweetDate,
+		"user":    userInfo.UserName,
+		"userId":  userInfo.UserId,
+		"index":   strconv.Itoa(index),
+	})
+}
+
+// errUnsupported marks media that is skipped because neither the API nor the
+// response tell what it is
+var errUnsupported = errors.New("media type not supported: response Content-Type and content not recognised")
+
+func downloadMedia(ctx context.Context, run *Run, mediaUrls string, ledgerKey string, name string, userInfo *user.UserInfo) (int64, error) {
+	c := collector.NewCollector(ctx, run.API)
+	c.AllowURLRevisit = true
 
-	c := collector.NewCollector()
+	var downloadErr error
+	var size int64
 
 	c.OnResponse(func(r *colly.Response) {
 		reqUrl := r.Request.URL.String()
-		fileName := strings.Split(reqUrl, "/")
-		dir := userInfo.SaveDir
-		lastPath := fileName[len(fileName)-1]
-		utils.SaveMediaFile(dir, strings.TrimSuffix(lastPath, extractSuffixAfterColon(lastPath)), r.Body)
-		config.LogRecord.AddURL(strings.TrimSuffix(reqUrl, extractSuffixAfterColon(reqUrl)))
+		kind, ext := utils.DetectMedia(r.Headers.Get("Content-Type"), r.Body)
+		if kind == "unknown" {
+			downloadErr = errUnsupported
+			return
+		}
+		if kind == "hls" {
+			size, downloadErr = downloadHLS(ctx, run, reqUrl, name, userInfo)
+			return
+		}
+
+		location, err := run.sink().Put(ctx, userInfo.SaveDir+name+ext, bytes.NewReader(r.Body), int64(len(r.Body)))
+		if err != nil {
+			downloadErr = report.Wrap(err, report.StageSave, "", "", reqUrl)
+			return
+		}
+		size = int64(len(r.Body))
+		run.Ledger.AddLocation(ledgerKey, location)
 	})
 
 	retryCount := 0
 	c.OnError(func(r *colly.Response, err error) {
 		retryUrl := r.Request.URL.String()
-		log.Println("Request URL:", r.Request.URL, "failed with response:", r, "\nError:", err)
-		log.Panicln("Retry download media: ", retryUrl, "retry count: ", retryCount)
+		downloadErr = report.Wrap(err, report.StageDownload, "", "", retryUrl)
+		// 4xx 表示该地址不存在或无权访问，重试没有意义
+		if ctx.Err() != nil || r.StatusCode >= 400 && r.StatusCode < 500 && r.StatusCode != 429 {
+			return
+		}
+		slog.Warn("download media failed", "url", retryUrl, "status", r.StatusCode, "err", err, "retry", retryCount)
 		if retryCount < 3 {
-			c.Visit(retryUrl)
 			retryCount++
+			downloadErr = nil
+			c.Visit(retryUrl)
 		} else {
-			log.Println("Retry download media failed: ", retryUrl)
+			slog.Error("retry download media failed", "url", retryUrl)
 		}
 	})
 
-	c.Visit(mediaUrls)
+	if err := c.Visit(mediaUrls); err != nil {
+		return 0, report.Wrap(err, report.StageDownload, "", "", mediaUrls)
+	}
 	c.Wait()
+	if downloadErr == nil && size == 0 && ctx.Err() != nil {
+		downloadErr = ctx.Err()
+	}
+
+	return size, downloadErr
+}
+
+// downloadImage 依次尝试原图的各个候选地址，任一成功即停止，下载记录始终使用不带参数的原始地址
+func downloadImage(ctx context.Context, run *Run, imageUrl string, name string, userInfo *user.UserInfo) (int64, error) {
+	var err error
+	for _, candidate := range utils.ImageURLCandidates(imageUrl, run.ImageFormats) {
+		var size int64
+		if size, err = downloadMedia(ctx, run, candidate, imageUrl, name, userInfo); err == nil || interrupted(err) {
+			return size, err
+		}
+	}
+	return 0, err
 }
 
-func processUrl(originUrl string, cachedUrls *int32, userInfo *user.UserInfo) {
-	url := utils.TrimURLQueryAndHash(originUrl)
-	if config.LogRecord.URLExists(url) {
-		fmt.Println("media already downloaded: ", url)
+func processUrl(ctx context.Context, run *Run, task mediaTask, cachedUrls *int32, userInfo *user.UserInfo) {
+	url := utils.TrimURLQueryAndHash(task.URL)
+	if ctx.Err() != nil {
+		run.emit(progress.Event{Kind: progress.MediaSkipped, User: userInfo.UserName, URL: url, Reason: "interrupted"})
+		return
+	}
+	if run.Ledger.URLExists(url) {
+		slog.Debug("media already downloaded", "user", userInfo.UserName, "url", url)
 		atomic.AddInt32(cachedUrls, 1)
+		run.emit(progress.Event{Kind: progress.MediaSkipped, User: userInfo.UserName, URL: url, Reason: "already downloaded"})
+		return
+	}
+	run.emit(progress.Event{Kind: progress.MediaQueued, User: userInfo.UserName, URL: url})
+
+	name := task.Name
+	if name == "" {
+		name = DefaultName(task.URL)
+	}
+	if run.DryRun {
+		planMedia(ctx, run, task, url, name, userInfo)
 		return
 	}
 
-	switch utils.FileType(url) {
+	var size int64
+	var err error
+	switch kind := utils.ClassifyMedia(task.MediaType, task.ContentType, task.URL); kind {
+	case "hls":
+		size, err = downloadHLS(ctx, run, task.URL, name, userInfo)
 	case "image":
-		downloadMedia(url + ":orig", userInfo)
+		size, err = downloadImage(ctx, run, url, name, userInfo)
 	case "audio", "video":
-		downloadMedia(url, userInfo)
+		size, err = downloadStream(ctx, run, url, url, name, userInfo)
 	default:
-		fmt.Println("media type not supported: ", url)
+		// 交给响应的Content-Type和文件头判断，仍无法识别时跳过
+		slog.Info("media type unknown, classifying from response", "user", userInfo.UserName, "url", url, "mediaType", task.MediaType, "contentType", task.ContentType)
+		size, err = downloadMedia(ctx, run, task.URL, url, name, userInfo)
+	}
+
+	switch {
+	case interrupted(err):
+		// 未完成的文件已删除，下次运行会重新下载
+		run.emit(progress.Event{Kind: progress.MediaSkipped, User: userInfo.UserName, URL: url, Reason: "interrupted"})
+	case errors.Is(err, errUnsupported):
+		slog.Warn("media skipped", "user", userInfo.UserName, "tweet", task.TweetId, "url", task.URL, "reason", err)
+		run.emit(progress.Event{Kind: progress.MediaSkipped, User: userInfo.UserName, URL: url, Reason: err.Error()})
+	case err != nil:
+		err = report.Wrap(err, report.StageDownload, userInfo.UserName, task.TweetId, task.URL)
+		run.Report.Add(err)
+		run.emit(progress.Event{Kind: progress.MediaFailed, User: userInfo.UserName, URL: url, Err: err})
+	default:
+		entry := IndexEntry{MediaItem: task.Item, Location: run.Ledger.Location(url), Bytes: size, Saved: time.Now()}
+		addThumbnail(ctx, run, &entry, name, userInfo)
+		if err := run.addToIndex(userInfo.SaveDir, entry); err != nil {
+			run.Report.Add(report.Wrap(err, report.StageSave, userInfo.UserName, task.TweetId, userInfo.SaveDir+IndexFileName))
+		}
+		run.emit(progress.Event{Kind: progress.MediaDone, User: userInfo.UserName, URL: url, Bytes: size})
 	}
 }
 
-func downloadMediaUrls(urls []string, userInfo *user.UserInfo) bool {
+func downloadMediaUrls(ctx context.Context, run *Run, tasks []mediaTask, userInfo *user.UserInfo) bool {
 	var cachedUrls int32
 	var wg sync.WaitGroup
 
-	for _, url := range urls {
+	for _, task := range tasks {
 		wg.Add(1)
-		go func(u string) {
+		go func(t mediaTask) {
 			defer wg.Done()
-			processUrl(u, &cachedUrls, userInfo)
-		}(url)
+			processUrl(ctx, run, t, &cachedUrls, userInfo)
+		}(task)
 	}
 
 	wg.Wait()
 
-	return int(cachedUrls) == len(urls)
+	// a page without media says nothing about what is already downloaded
+	return len(tasks) > 0 && int(cachedUrls) == len(tasks)
 }
 
-func extractMediaInfo(jsonContentStr string) []utils.Legacy {
+func extractMediaInfo(jsonContentStr string) ([]utils.Legacy, error) {
 	const prefixJsonPath = "data.user.result.timeline_v2.timeline."
-	const firstPageInfixJsonPath = "instructions.#.entries.#.content.items.#."
-	const infixJsonPath = "instructions.#.moduleItems.#."
-	const suffixJsonPath = "item.itemContent.tweet_results.result.legacy"
-
-	mediaListJsonRes := gjson.Get(jsonContentStr, prefixJsonPath+firstPageInfixJsonPath+suffixJsonPath)
-	flattenedSlice := utils.Flatten(mediaListJsonRes.Value())
-	arrayLikeString, _ := utils.SliceToJSONString(flattenedSlice)
-	mediaInfoList, _ := utils.ExtractMedias(arrayLikeString)
-
-	if len(mediaInfoList) == 0 {
-			mediaListJsonRes = gjson.Get(jsonContentStr, prefixJsonPath+infixJsonPath+suffixJsonPath)
-			flattenedSlice = utils.Flatten(mediaListJsonRes.Value())
-			arrayLikeString, _ = utils.SliceToJSONString(flattenedSlice)
-	}
-	legacyList, _ := utils.ExtractLegacyList(arrayLikeString)
-	return legacyList
+	const suffixJsonPath = "itemContent.tweet_results.result.legacy"
+	// 媒体时间线首页在 entries 的 items 中，之后的页在 moduleItems 中；
+	// 喜欢时间线的推文直接是 entries
+	infixJsonPaths := []string{
+		"instructions.#.entries.#.content.items.#.item.",
+		"instructions.#.moduleItems.#.item.",
+		"instructions.#.entries.#.content.",
+	}
+
+	arrayLikeString := "[]"
+	for _, infixJsonPath := range infixJsonPaths {
+		mediaListJsonRes := gjson.Get(jsonContentStr, prefixJsonPath+infixJsonPath+suffixJsonPath)
+		if !mediaListJsonRes.Exists() {
+			continue
+		}
+		flattenedSlice := utils.Flatten(mediaListJsonRes.Value())
+		if len(flattenedSlice) == 0 {
+			continue
+		}
+		var err error
+		if arrayLikeString, err = utils.SliceToJSONString(flattenedSlice); err != nil {
+			return nil, err
+		}
+		break
+	}
+	return utils.ExtractLegacyList(arrayLikeString)
 }
 
 func extractNextPageTokenValue(json, keyword string) string {
@@ -129,7 +262,7 @@ func extractNextPageTokenValue(json, keyword string) string {
 	return result
 }
 
-func generateTwitterMediaUrl(userInfoCache *user.UserInfo) string {
+func generateTwitterMediaUrl(api collector.API, userInfoCache *user.UserInfo, source Source) string {
 
 	createVariables := func(userId string, cursor string) string {
 		variables := map[string]interface{}{
@@ -145,7 +278,7 @@ func generateTwitterMediaUrl(userInfoCache *user.UserInfo) string {
 
 		variablesData, err := json.Marshal(variables)
 		if err != nil {
-			log.Println(err)
+			slog.Error("encode timeline request", "err", err)
 			return ""
 		}
 
@@ -183,7 +316,7 @@ func generateTwitterMediaUrl(userInfoCache *user.UserInfo) string {
 
 		featuresData, err := json.Marshal(features)
 		if err != nil {
-			log.Println(err)
+			slog.Error("encode timeline request", "err", err)
 			return ""
 		}
 
@@ -194,64 +327,198 @@ func generateTwitterMediaUrl(userInfoCache *user.UserInfo) string {
 
 	queryParams.Add("variables", createVariables(userInfoCache.UserId, userInfoCache.NextPageToken))
 	queryParams.Add("features", createFeatures())
-	twitterMediaUrl := &url.URL{
-		Scheme:   "https",
-		Host:     "twitter.com",
-		Path:     "/i/api/graphql/aQQLnkexAl5z9ec_UgbEIA/UserMedia",
-		RawQuery: queryParams.Encode(),
-	}
-	return twitterMediaUrl.String()
+	return api.URL("/i/api/graphql/"+source.endpoint(), queryParams)
 }
 
-func DownloadTwitterMedia(userInfoCache *user.UserInfo, csvList *[]utils.CSV) {
-	c := collector.NewCollector()
-
-	handleMediaInfoResp := func(r *colly.Response) {
-			legacyList := extractMediaInfo(string(r.Body))
-			var flattenedArray []string
-			for _, legacyItm := range legacyList {
-					for _, media := range legacyItm.Extended.Media {
-							*csvList = append(*csvList, utils.CSV{
-									TweetDate:   utils.ParseTwitterTime(legacyItm.CreatedAt),
-									TweetId:     legacyItm.TweetID,
-									Username:    "@" + userInfoCache.UserName,
-									DisplayName: userInfoCache.DisplayName,
-									TweetText:   legacyItm.TweetText,
-									TweetURL:    media.ExpandedUrl,
-									MediaType:   media.Type,
-									MediaURL:    media.MediaURL,
-							})
-							if media.IsVideo {
-									flattenedArray = append(flattenedArray, utils.FindMaxBitrateURL(media))
-							} else {
-									flattenedArray = append(flattenedArray, media.MediaURL)
-							}
-					}
-			}
+// downloadMediaPage crawls the page of source at userInfoCache.NextPageToken
+// and downloads its media
+func downloadMediaPage(ctx context.Context, run *Run, source Source, page int, userInfoCache *user.UserInfo, csvList *[]utils.CSV) (pageResult, error) {
+	timeline, err := fetchTimelinePage(ctx, run.API, source, userInfoCache)
+	if err != nil {
+		return pageResult{}, err
+	}
+	result := pageResult{
+		tweets:        timeline.tweets,
+		nextCursor:    timeline.nextCursor,
+		newestTweetId: timeline.newestTweetId,
+		oldestTweetId: timeline.oldestTweetId,
+	}
+
+	var tasks []mediaTask
+	for _, item := range timeline.items {
+		if run.Filters.Since != "" && item.TweetDate != "" && item.TweetDate < run.Filters.Since {
+			// 媒体时间线从新到旧排列，更早的页只会更旧
+			result.pastSince = source.OrderedByTweet()
+			continue
+		}
+		if !run.Filters.AllowsMediaType(item.Type) {
+			run.emit(progress.Event{Kind: progress.MediaSkipped, User: userInfoCache.UserName, URL: item.MediaURL, Reason: "filtered: " + item.Type})
+			continue
+		}
+		*csvList = append(*csvList, item.csvRow(userInfoCache))
+		tasks = append(tasks, item.task(run, userInfoCache))
+	}
 
-			isPageDownloaded := downloadMediaUrls(flattenedArray, userInfoCache)
+	run.emit(progress.Event{Kind: progress.PageCrawled, User: userInfoCache.UserName, Count: page})
+	run.emit(progress.Event{Kind: progress.MediaDiscovered, User: userInfoCache.UserName, Count: len(tasks)})
+	result.allKnown = downloadMediaUrls(ctx, run, tasks, userInfoCache)
+	if ctx.Err() != nil {
+		return result, ctx.Err()
+	}
+	return result, nil
+}
 
-			if len(flattenedArray) == 0 || isPageDownloaded {
-				fmt.Println("no more media. task completed.")
-				config.LogRecord.SaveToFile()
-				utils.SaveToCSV(*csvList, "record.csv")
-				return
-			}
+// DownloadTwitterMedia crawls every timeline in run.Sources page by page
+// according to run.SyncMode, then saves the ledger, unless the sink is
+// closed later, and appends the collected
+// rows to record.csv unless run.DryRun is set. Every failure is added to run.Report with its user,
+// tweet, URL and stage, and progress is emitted to run.Events.
+func DownloadTwitterMedia(ctx context.Context, run *Run, userInfoCache *user.UserInfo, csvList *[]utils.CSV) {
+	mode := run.SyncMode
+	if mode == "" {
+		mode = SyncIncremental
+	}
+	sources := run.Sources
+	if len(sources) == 0 {
+		sources = []Source{SourceMedia}
+	}
 
-			const prefixJsonPath = "data.user.result.timeline_v2.timeline."
-			const nextTokenJsonPath = "instructions.#.entries"
-	
-			nextTokenJsonData := gjson.Get(string(r.Body), prefixJsonPath+nextTokenJsonPath)
-	
-			userInfoCache.NextPageToken = extractNextPageTokenValue(nextTokenJsonData.String(), "bottom")
-	
-			go func() {
-				DownloadTwitterMedia(userInfoCache, csvList)
-			}()
+	run.emit(progress.Event{Kind: progress.UserStarted, User: userInfoCache.UserName, Mode: string(mode)})
+	defer run.emit(progress.Event{Kind: progress.UserFinished, User: userInfoCache.UserName})
+
+	for _, source := range sources {
+		sourceInfo := *userInfoCache
+		sourceInfo.SaveDir += source.subDir()
+		if ctx.Err() != nil {
+			break
+		}
+		syncTimeline(ctx, run, source, mode, &sourceInfo, csvList)
 	}
-	c.OnResponse(handleMediaInfoResp)
 
-	c.Visit(generateTwitterMediaUrl(userInfoCache))
-	c.Wait()
+	if run.DryRun {
+		return
+	}
+	if err := run.saveLedger(); err != nil {
+		run.Report.Add(report.Wrap(err, report.StageLedger, userInfoCache.UserName, "", ""))
+	}
+	if appender, ok := run.sink().(sink.Appender); ok {
+		// sinks that collect files, like bundles, keep the user's rows with
+		// the user's media instead of in the shared record.csv
+		csvPath := userInfoCache.SaveDir + "record.csv"
+		var header, rows bytes.Buffer
+		utils.WriteCSV(&header, nil, true)
+		err := utils.WriteCSV(&rows, *csvList, false)
+		if err == nil {
+			err = appender.Append(csvPath, rows.Bytes(), header.Bytes())
+		}
+		if err != nil {
+			run.Report.Add(report.Wrap(err, report.StageCSV, userInfoCache.UserName, "", csvPath))
+		}
+	} else if run.RecordCSV != "" {
+		if err := utils.SaveToCSV(*csvList, run.RecordCSV); err != nil {
+			run.Report.Add(report.Wrap(err, report.StageCSV, userInfoCache.UserName, "", run.RecordCSV))
+		}
+	}
 }
 
+// syncTimeline walks one timeline and keeps its sync state next to the
+// media it saves
+func syncTimeline(ctx context.Context, run *Run, source Source, mode SyncMode, userInfoCache *user.UserInfo, csvList *[]utils.CSV) {
+	knownPagesLimit := run.KnownPages
+	if knownPagesLimit <= 0 {
+		knownPagesLimit = DefaultKnownPages
+	}
+
+	statePath := userInfoCache.SaveDir + syncStateFile
+	state, err := storage.LoadSyncState(statePath)
+	if err != nil {
+		run.Report.Add(report.Wrap(err, report.StageState, userInfoCache.UserName, "", statePath))
+	}
+	saveState := func() {
+		if run.DryRun {
+			return
+		}
+		if err := os.MkdirAll(userInfoCache.SaveDir, 0755); err != nil {
+			run.Report.Add(report.Wrap(err, report.StageState, userInfoCache.UserName, "", statePath))
+			return
+		}
+		if err := storage.SaveSyncState(statePath, state); err != nil {
+			run.Report.Add(report.Wrap(err, report.StageState, userInfoCache.UserName, "", statePath))
+		}
+	}
+
+	userInfoCache.NextPageToken = ""
+	if mode == SyncBackfill {
+		if state.OldestCursor == "" {
+			slog.Info("no backfill cursor stored, starting from the newest page", "user", userInfoCache.UserName, "source", source)
+		}
+		userInfoCache.NextPageToken = state.OldestCursor
+	}
+
+	var newestTweetId string
+	failuresBefore := len(run.Report.Errors())
+	finished := false
+	knownPages := 0
+	for page := 1; ; page++ {
+		result, err := downloadMediaPage(ctx, run, source, page, userInfoCache, csvList)
+		if interrupted(err) {
+			slog.Info("interrupted, saving sync state", "user", userInfoCache.UserName, "source", source)
+			break
+		}
+		if err != nil {
+			run.Report.Add(err)
+			break
+		}
+		if storage.CompareTweetIds(result.newestTweetId, newestTweetId) > 0 {
+			newestTweetId = result.newestTweetId
+		}
+
+		if result.pastSince {
+			slog.Info("reached tweets older than the since filter. task completed.", "user", userInfoCache.UserName, "since", run.Filters.Since)
+			finished = true
+			break
+		}
+		if result.tweets == 0 || result.nextCursor == "" {
+			slog.Info("no more media. task completed.", "user", userInfoCache.UserName, "source", source, "mode", mode)
+			state.Complete = true
+			finished = true
+			break
+		}
+
+		if mode != SyncIncremental {
+			// full and backfill walk deeper than before, so remember where to resume
+			state.OldestCursor = result.nextCursor
+			saveState()
+		} else {
+			if result.allKnown {
+				knownPages++
+			} else {
+				knownPages = 0
+			}
+			if source.OrderedByTweet() && state.HighWaterTweetId != "" && storage.CompareTweetIds(result.oldestTweetId, state.HighWaterTweetId) <= 0 {
+				slog.Info("reached high-water tweet. task completed.", "user", userInfoCache.UserName, "tweet", state.HighWaterTweetId)
+				finished = true
+				break
+			}
+			if knownPages >= knownPagesLimit {
+				slog.Info("no new media on recent pages. task completed.", "user", userInfoCache.UserName, "source", source, "pages", knownPages)
+				finished = true
+				break
+			}
+		}
+		userInfoCache.NextPageToken = result.nextCursor
+	}
+
+	// only a crawl that ran to its stop condition may move the high-water mark,
+	// otherwise the next incremental run would skip the pages never reached
+	if finished && mode != SyncBackfill {
+		var failed []string
+		for _, e := range run.Report.Errors()[failuresBefore:] {
+			if e.Stage == report.StageDownload || e.Stage == report.StageSave {
+				failed = append(failed, e.TweetId)
+			}
+		}
+		state.AdvanceHighWater(newestTweetId, failed)
+	}
+	saveState()
+}
@@ -0,0 +1,120 @@
+package download
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"twitterDownload/pkg/collector"
+	"twitterDownload/pkg/storage"
+	"twitterDownload/pkg/user"
+	"twitterDownload/pkg/utils"
+)
+
+// timelineServer serves a media timeline of pages of one tweet each, the
+// n-th page at cursor "p<n>", followed by an empty page. Tweets of pages in
+// withMedia have a photo served by the same server.
+func timelineServer(t *testing.T, pages int, withMedia map[int]bool) (*httptest.Server, *[]int) {
+	t.Helper()
+	var requested []int
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if filepath.Dir(r.URL.Path) == "/media" {
+			w.Header().Set("Content-Type", "image/jpeg")
+			w.Write([]byte("\xff\xd8\xff\xe0 photo"))
+			return
+		}
+		var variables struct {
+			Cursor string `json:"cursor"`
+		}
+		if err := json.Unmarshal([]byte(r.URL.Query().Get("variables")), &variables); err != nil {
+			t.Errorf("variables: %v", err)
+		}
+		page := 1
+		if variables.Cursor != "" {
+			page, _ = strconv.Atoi(variables.Cursor[1:])
+		}
+		requested = append(requested, page)
+
+		var entries []any
+		if page <= pages {
+			legacy := map[string]any{
+				"id_str":     strconv.Itoa(1000 - page),
+				"created_at": "Wed Oct 10 20:19:24 +0000 2018",
+				"full_text":  "tweet",
+			}
+			if withMedia[page] {
+				legacy["extended_entities"] = map[string]any{"media": []any{map[string]any{
+					"type":            "photo",
+					"media_url_https": fmt.Sprintf("%s/media/p%d.jpg", srv.URL, page),
+				}}}
+			}
+			entries = append(entries,
+				map[string]any{"entryId": "tweet-" + legacy["id_str"].(string), "content": map[string]any{
+					"itemContent": map[string]any{"tweet_results": map[string]any{"result": map[string]any{"legacy": legacy}}},
+				}},
+				map[string]any{"entryId": "cursor-bottom-1", "content": map[string]any{"value": fmt.Sprintf("p%d", page+1)}},
+			)
+		}
+		body := map[string]any{"data": map[string]any{"user": map[string]any{"result": map[string]any{
+			"timeline_v2": map[string]any{"timeline": map[string]any{"instructions": []any{map[string]any{"entries": entries}}}},
+		}}}}
+		json.NewEncoder(w).Encode(body)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &requested
+}
+
+func TestIncrementalSyncSkipsPagesWithoutMedia(t *testing.T) {
+	srv, requested := timelineServer(t, 6, map[int]bool{6: true})
+	dir := t.TempDir() + string(os.PathSeparator)
+
+	run := NewRun()
+	run.API = collector.API{Cookie: "ct0=token", Base: srv.URL, Client: srv.Client(), Concurrency: 1}
+	userInfo := &user.UserInfo{UserId: "1", UserName: "bob", SaveDir: dir}
+	var rows []utils.CSV
+	syncTimeline(context.Background(), run, SourceMedia, SyncIncremental, userInfo, &rows)
+
+	if len(*requested) != 7 {
+		t.Fatalf("requested pages %v, want 1 to 7", *requested)
+	}
+	if errs := run.Report.Errors(); len(errs) > 0 {
+		t.Fatalf("errors: %v", errs)
+	}
+	if media := srv.URL + "/media/p6.jpg"; !run.Ledger.URLExists(media) {
+		t.Errorf("%s was not downloaded", media)
+	}
+	state, err := storage.LoadSyncState(dir + syncStateFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !state.Complete || state.HighWaterTweetId != "999" {
+		t.Errorf("state = %+v, want complete at 999", state)
+	}
+}
+
+func TestIncrementalSyncStopsAfterKnownPages(t *testing.T) {
+	withMedia := map[int]bool{1: true, 2: true, 3: true, 4: true, 5: true}
+	srv, requested := timelineServer(t, 5, withMedia)
+	dir := t.TempDir() + string(os.PathSeparator)
+
+	run := NewRun()
+	run.API = collector.API{Cookie: "ct0=token", Base: srv.URL, Client: srv.Client(), Concurrency: 1}
+	run.KnownPages = 2
+	for page := 1; page <= 5; page++ {
+		run.Ledger.AddLocation(fmt.Sprintf("%s/media/p%d.jpg", srv.URL, page), "elsewhere")
+	}
+	userInfo := &user.UserInfo{UserId: "1", UserName: "bob", SaveDir: dir}
+	var rows []utils.CSV
+	syncTimeline(context.Background(), run, SourceMedia, SyncIncremental, userInfo, &rows)
+
+	if len(*requested) != 2 {
+		t.Errorf("requested pages %v, want 1 and 2", *requested)
+	}
+}
@@ -0,0 +1,52 @@
+package download
+
+import (
+	"context"
+	"os"
+
+	"twitterDownload/pkg/hls"
+	"twitterDownload/pkg/progress"
+	"twitterDownload/pkg/report"
+	"twitterDownload/pkg/sink"
+	"twitterDownload/pkg/user"
+	"twitterDownload/pkg/utils"
+)
+
+// downloadHLS fetches an m3u8 stream into a .part file and renames it once
+// the container, and therefore the extension, is known
+func downloadHLS(ctx context.Context, run *Run, playlistUrl string, name string, userInfo *user.UserInfo) (int64, error) {
+	dir := userInfo.SaveDir
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return 0, report.Wrap(err, report.StageSave, "", "", playlistUrl)
+	}
+
+	partPath := dir + name + ".part"
+	file, err := os.Create(partPath)
+	if err != nil {
+		return 0, report.Wrap(err, report.StageSave, "", "", playlistUrl)
+	}
+
+	run.emit(progress.Event{Kind: progress.FileStarted, User: userInfo.UserName, URL: playlistUrl})
+	d := hls.Downloader{
+		Client:      run.API.HTTPClient(),
+		Concurrency: run.API.Concurrency,
+		Progress: func(bytes int64, done int, total int) {
+			run.emit(progress.Event{Kind: progress.FileProgress, User: userInfo.UserName, URL: playlistUrl, Bytes: bytes, Fraction: float64(done) / float64(total)})
+		},
+	}
+	result, err := d.Download(ctx, playlistUrl, file)
+	file.Close()
+	if err != nil {
+		run.emit(progress.Event{Kind: progress.FileDone, User: userInfo.UserName, URL: playlistUrl})
+		os.Remove(partPath)
+		return 0, report.Wrap(err, report.StageDownload, "", "", playlistUrl)
+	}
+	run.emit(progress.Event{Kind: progress.FileDone, User: userInfo.UserName, URL: playlistUrl, Bytes: result.Bytes})
+
+	location, err := sink.PutFile(ctx, run.sink(), partPath, dir+name+result.Ext)
+	if err != nil {
+		return 0, report.Wrap(err, report.StageSave, "", "", playlistUrl)
+	}
+	run.Ledger.AddLocation(utils.TrimURLQueryAndHash(playlistUrl), location)
+	return result.Bytes, nil
+}
@@ -0,0 +1,118 @@
+package download
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// IndexFileName is the sidecar in every folder media are saved to. It lists
+// each saved file with the tweet it came from, one JSON object per line, so
+// galleries and exports can be built without crawling again.
+const IndexFileName = "media.jsonl"
+
+// IndexEntry is one saved media file
+type IndexEntry struct {
+	MediaItem
+	// Location is where the file went: a local path or a sink's key
+	Location string    `json:"location"`
+	Bytes    int64     `json:"bytes"`
+	Saved    time.Time `json:"saved"`
+	// Thumb is the location of the JPEG thumbnail, empty when none was made
+	Thumb string `json:"thumb,omitempty"`
+	// SHA256 is the hex hash of the file, set when it was imported
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+// IsLocalLocation reports whether a location is a path on the local disk
+// rather than a remote object (scheme://...) or an archive entry
+// (archive!entry)
+func IsLocalLocation(location string) bool {
+	return location != "" && !strings.Contains(location, "://") && !strings.Contains(location, "!")
+}
+
+// addToIndex appends an entry to the index of dir
+func (r *Run) addToIndex(dir string, entry IndexEntry) error {
+	r.indexMu.Lock()
+	defer r.indexMu.Unlock()
+	return AppendIndex(dir, entry)
+}
+
+// AppendIndex appends entries to the index of dir, creating both when
+// missing. Appends to the same folder must not run concurrently.
+func AppendIndex(dir string, entries ...IndexEntry) error {
+	data, err := encodeIndex(entries)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filepath.Join(dir, IndexFileName), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	return errors.Join(err, f.Close())
+}
+
+// WriteIndex replaces the index of dir with entries, e.g. to drop the
+// entries of deleted files
+func WriteIndex(dir string, entries []IndexEntry) error {
+	data, err := encodeIndex(entries)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(dir, IndexFileName)
+	if err := os.WriteFile(path+".part", data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(path+".part", path)
+}
+
+func encodeIndex(entries []IndexEntry) ([]byte, error) {
+	var data []byte
+	for _, entry := range entries {
+		line, err := json.Marshal(entry)
+		if err != nil {
+			return nil, err
+		}
+		data = append(append(data, line...), '\n')
+	}
+	return data, nil
+}
+
+// ReadIndex reads the index of dir. A folder without one has no entries.
+// When a file was saved more than once the last entry wins.
+func ReadIndex(dir string) ([]IndexEntry, error) {
+	f, err := os.Open(filepath.Join(dir, IndexFileName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []IndexEntry
+	seen := make(map[string]int)
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var entry IndexEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return entries, err
+		}
+		if i, ok := seen[entry.URL]; ok {
+			entries[i] = entry
+			continue
+		}
+		seen[entry.URL] = len(entries)
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
@@ -0,0 +1,86 @@
+package download
+
+import (
+	"context"
+
+	"twitterDownload/pkg/collector"
+	"twitterDownload/pkg/user"
+)
+
+// MediaStream yields the media items of one timeline, newest first, on C
+// while it crawls the timeline page by page in the background. Nothing is
+// downloaded, consumers decide what to do with each item.
+//
+// C is closed when the timeline ends, a page fails or the context is
+// cancelled. Err and Cursor may be read once C is closed.
+type MediaStream struct {
+	C <-chan MediaItem
+
+	cursor string
+	err    error
+}
+
+// StreamMedia starts crawling the timeline of source at cursor, the newest
+// page when cursor is empty
+func StreamMedia(ctx context.Context, api collector.API, source Source, userInfo user.UserInfo, cursor string) *MediaStream {
+	ch := make(chan MediaItem)
+	s := &MediaStream{C: ch, cursor: cursor}
+	go func() {
+		defer close(ch)
+		s.err = s.crawl(ctx, api, source, userInfo, ch)
+	}()
+	return s
+}
+
+func (s *MediaStream) crawl(ctx context.Context, api collector.API, source Source, userInfo user.UserInfo, ch chan<- MediaItem) error {
+	for {
+		userInfo.NextPageToken = s.cursor
+		page, err := fetchTimelinePage(ctx, api, source, &userInfo)
+		if err != nil {
+			return err
+		}
+		for _, item := range page.items {
+			select {
+			case ch <- item:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		if page.tweets == 0 || page.nextCursor == "" {
+			s.cursor = ""
+			return nil
+		}
+		s.cursor = page.nextCursor
+	}
+}
+
+// Err is why the stream stopped early, nil when the timeline was read to
+// its end
+func (s *MediaStream) Err() error {
+	return s.err
+}
+
+// Cursor is the page after the last one whose items were all delivered.
+// Passing it to StreamMedia resumes the crawl there; it is empty once the
+// timeline was read to its end.
+func (s *MediaStream) Cursor() string {
+	return s.cursor
+}
+
+// IterateMedia walks the timeline of source from the newest page and calls
+// fn for every media item until fn returns an error, the timeline ends or ctx
+// is cancelled. Nothing is downloaded.
+func IterateMedia(ctx context.Context, api collector.API, source Source, userInfo user.UserInfo, fn func(MediaItem) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stream := StreamMedia(ctx, api, source, userInfo, "")
+	for item := range stream.C {
+		if err := fn(item); err != nil {
+			cancel()
+			for range stream.C {
+			}
+			return err
+		}
+	}
+	return stream.Err()
+}
@@ -0,0 +1,150 @@
+package download
+
+import (
+	"context"
+
+	"twitterDownload/pkg/collector"
+	"twitterDownload/pkg/report"
+	"twitterDownload/pkg/storage"
+	"twitterDownload/pkg/user"
+	"twitterDownload/pkg/utils"
+
+	"github.com/gocolly/colly"
+	"github.com/tidwall/gjson"
+)
+
+// MediaItem is one media attachment of a tweet found on a timeline
+type MediaItem struct {
+	// User is the screen name of the timeline's owner and Source the
+	// timeline the item was found on
+	User    string `json:"user,omitempty"`
+	Source  Source `json:"source,omitempty"`
+	TweetId string `json:"tweetId"`
+	// TweetDate is the day the tweet was posted, e.g. 2024-01-31
+	TweetDate string `json:"tweetDate"`
+	TweetText string `json:"tweetText"`
+	TweetURL  string `json:"tweetUrl"`
+	AuthorId  string `json:"authorId"`
+	// Index is the position of the media in its tweet, starting at 1
+	Index int `json:"index"`
+	// Type is photo, video or animated_gif
+	Type     string `json:"type"`
+	MediaKey string `json:"mediaKey,omitempty"`
+	// Width and Height are the original size, DurationMs the length of
+	// videos
+	Width      int    `json:"width,omitempty"`
+	Height     int    `json:"height,omitempty"`
+	DurationMs int    `json:"durationMs,omitempty"`
+	MediaURL   string `json:"mediaUrl"`
+	// URL is what gets downloaded: the best video variant or the image
+	URL         string `json:"url"`
+	ContentType string `json:"contentType,omitempty"`
+	Bitrate     int    `json:"bitrate,omitempty"`
+}
+
+// newMediaItems flattens the media of one tweet
+func newMediaItems(legacy utils.Legacy) []MediaItem {
+	tweetDate := utils.ParseTwitterTime(legacy.CreatedAt)
+	items := make([]MediaItem, 0, len(legacy.Extended.Media))
+	for i, media := range legacy.Extended.Media {
+		item := MediaItem{
+			TweetId:    legacy.TweetID,
+			TweetDate:  tweetDate,
+			TweetText:  legacy.TweetText,
+			TweetURL:   media.ExpandedUrl,
+			AuthorId:   legacy.UserID,
+			Index:      i + 1,
+			Type:       media.Type,
+			MediaKey:   media.MediaKey,
+			Width:      media.OriginalInfo.Width,
+			Height:     media.OriginalInfo.Height,
+			DurationMs: media.VideoInfo.DurationMillis,
+			MediaURL:   media.MediaURL,
+			URL:        media.MediaURL,
+		}
+		if variant, ok := utils.FindBestVariant(media); ok {
+			item.URL, item.ContentType, item.Bitrate = variant.URL, variant.ContentType, variant.Bitrate
+		}
+		items = append(items, item)
+	}
+	return items
+}
+
+// task turns an item into the download task named by run.NameTemplate
+func (item MediaItem) task(run *Run, userInfo *user.UserInfo) mediaTask {
+	task := mediaTask{URL: item.URL, MediaType: item.Type, ContentType: item.ContentType, TweetId: item.TweetId, Item: item}
+	task.Name = fileName(run, task, item.Index, item.TweetDate, userInfo)
+	return task
+}
+
+// csvRow is the record.csv row of an item on the timeline of userInfo
+func (item MediaItem) csvRow(userInfo *user.UserInfo) utils.CSV {
+	return utils.CSV{
+		TweetDate:   item.TweetDate,
+		TweetId:     item.TweetId,
+		Username:    "@" + userInfo.UserName,
+		DisplayName: userInfo.DisplayName,
+		TweetText:   item.TweetText,
+		TweetURL:    item.TweetURL,
+		MediaType:   item.Type,
+		MediaURL:    item.MediaURL,
+	}
+}
+
+// timelinePage is one parsed page of a timeline
+type timelinePage struct {
+	items []MediaItem
+	// tweets counts the tweets on the page, zero once the timeline ended
+	tweets        int
+	newestTweetId string
+	oldestTweetId string
+	nextCursor    string
+}
+
+// fetchTimelinePage requests the page of source at userInfo.NextPageToken
+// and parses its media without downloading anything
+func fetchTimelinePage(ctx context.Context, api collector.API, source Source, userInfo *user.UserInfo) (timelinePage, error) {
+	c := collector.NewCollector(ctx, api)
+	var page timelinePage
+	var pageErr error
+
+	c.OnResponse(func(r *colly.Response) {
+		legacyList, err := extractMediaInfo(string(r.Body))
+		if err != nil {
+			pageErr = report.Wrap(err, report.StageParse, userInfo.UserName, "", r.Request.URL.String())
+			return
+		}
+		for _, legacy := range legacyList {
+			if legacy.TweetID == "" {
+				continue
+			}
+			page.tweets++
+			if storage.CompareTweetIds(legacy.TweetID, page.newestTweetId) > 0 {
+				page.newestTweetId = legacy.TweetID
+			}
+			if page.oldestTweetId == "" || storage.CompareTweetIds(legacy.TweetID, page.oldestTweetId) < 0 {
+				page.oldestTweetId = legacy.TweetID
+			}
+			for _, item := range newMediaItems(legacy) {
+				item.User, item.Source = userInfo.UserName, source
+				page.items = append(page.items, item)
+			}
+		}
+
+		const nextTokenJsonPath = "data.user.result.timeline_v2.timeline.instructions.#.entries"
+		nextTokenJsonData := gjson.Get(string(r.Body), nextTokenJsonPath)
+		page.nextCursor = extractNextPageTokenValue(nextTokenJsonData.String(), "bottom")
+	})
+	c.OnError(func(r *colly.Response, err error) {
+		pageErr = report.Wrap(err, report.StageTimeline, userInfo.UserName, "", r.Request.URL.String())
+	})
+
+	if err := c.Visit(generateTwitterMediaUrl(api, userInfo, source)); err != nil {
+		return page, report.Wrap(err, report.StageTimeline, userInfo.UserName, "", "")
+	}
+	c.Wait()
+	if ctx.Err() != nil {
+		return page, ctx.Err()
+	}
+	return page, pageErr
+}
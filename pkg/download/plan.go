@@ -0,0 +1,70 @@
+package download
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"twitterDownload/pkg/progress"
+	"twitterDownload/pkg/user"
+	"twitterDownload/pkg/utils"
+)
+
+// headMedia asks for the size and Content-Type of a media URL without
+// fetching its body
+func headMedia(ctx context.Context, run *Run, mediaUrl string) (int64, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, mediaUrl, nil)
+	if err != nil {
+		return 0, "", err
+	}
+	resp, err := run.API.HTTPClient().Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, "", fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return max(resp.ContentLength, 0), resp.Header.Get("Content-Type"), nil
+}
+
+// planMedia is what processUrl does on a dry run: it resolves where a media
+// item would be saved and estimates its size, writing nothing
+func planMedia(ctx context.Context, run *Run, task mediaTask, mediaUrl string, name string, userInfo *user.UserInfo) {
+	kind := utils.ClassifyMedia(task.MediaType, task.ContentType, task.URL)
+	candidates := []string{task.URL}
+	if kind == "image" {
+		candidates = utils.ImageURLCandidates(mediaUrl, run.ImageFormats)
+	}
+
+	var size int64
+	var contentType string
+	var err error
+	// the size of an HLS stream is only known once its segments are fetched
+	if kind != "hls" {
+		for _, candidate := range candidates {
+			if size, contentType, err = headMedia(ctx, run, candidate); err == nil || interrupted(err) {
+				break
+			}
+		}
+	}
+	if interrupted(err) {
+		run.emit(progress.Event{Kind: progress.MediaSkipped, User: userInfo.UserName, URL: mediaUrl, Reason: "interrupted"})
+		return
+	}
+	if err != nil {
+		slog.Warn("estimate media size", "user", userInfo.UserName, "url", mediaUrl, "err", err)
+	}
+
+	ext := utils.ExtFromContentType(contentType)
+	switch {
+	case kind == "hls":
+		ext = ".mp4"
+	case ext == "":
+		ext = utils.URLExt(task.URL)
+	}
+	path := userInfo.SaveDir + name + ext
+	slog.Info("would download", "user", userInfo.UserName, "tweet", task.TweetId, "url", task.URL, "path", path, "bytes", size)
+	run.emit(progress.Event{Kind: progress.MediaPlanned, User: userInfo.UserName, URL: mediaUrl, Path: path, Bytes: size})
+}
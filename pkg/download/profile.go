@@ -0,0 +1,106 @@
+package download
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"twitterDownload/pkg/collector"
+	"twitterDownload/pkg/report"
+	"twitterDownload/pkg/user"
+	"twitterDownload/pkg/utils"
+
+	"github.com/gocolly/colly"
+)
+
+// fetchBytes downloads url and returns its body and Content-Type
+func fetchBytes(ctx context.Context, api collector.API, url string) ([]byte, string, error) {
+	c := collector.NewCollector(ctx, api)
+	var (
+		body        []byte
+		contentType string
+		fetchErr    error
+	)
+	c.OnResponse(func(r *colly.Response) {
+		body, contentType = r.Body, r.Headers.Get("Content-Type")
+	})
+	c.OnError(func(r *colly.Response, err error) {
+		fetchErr = fmt.Errorf("%s: %w", url, err)
+	})
+	c.Visit(url)
+	c.Wait()
+	if fetchErr == nil && body == nil && ctx.Err() != nil {
+		fetchErr = ctx.Err()
+	}
+	return body, contentType, fetchErr
+}
+
+// saveProfileImage downloads a profile image as <prefix>-<stamp>.<ext> into
+// the profile folder and returns the saved file name
+func saveProfileImage(ctx context.Context, run *Run, imageUrl string, prefix string, stamp string, dir string) (string, error) {
+	body, contentType, err := fetchBytes(ctx, run.API, imageUrl)
+	if err != nil {
+		return "", err
+	}
+	_, ext := utils.DetectMedia(contentType, body)
+	if ext == "" {
+		ext = ".jpg"
+	}
+	fileName := prefix + "-" + stamp + ext
+	if _, err := run.sink().Put(ctx, dir+fileName, bytes.NewReader(body), int64(len(body))); err != nil {
+		return "", err
+	}
+	return fileName, nil
+}
+
+// DownloadProfile archives the avatar, banner and profile fields of a user
+// under <SaveDir>/profile. Images are only fetched again when their URL
+// changed, and a timestamped snapshot is kept whenever the profile changed.
+func DownloadProfile(ctx context.Context, run *Run, userInfo *user.UserInfo) {
+	if ctx.Err() != nil || run.DryRun {
+		return
+	}
+	latest, hasLatest, err := user.LoadLatestSnapshot(userInfo.SaveDir)
+	if err != nil {
+		run.Report.Add(report.Wrap(err, report.StageProfile, userInfo.UserName, "", ""))
+	}
+
+	snapshot := user.NewProfileSnapshot(userInfo, time.Now())
+	profileDir := filepath.Join(userInfo.SaveDir, user.ProfileDirName) + "/"
+
+	if hasLatest && latest.AvatarURL == snapshot.AvatarURL && latest.AvatarFile != "" {
+		snapshot.AvatarFile = latest.AvatarFile
+	} else if snapshot.AvatarURL != "" {
+		snapshot.AvatarFile, err = saveProfileImage(ctx, run, user.FullSizeAvatarURL(snapshot.AvatarURL), "avatar", snapshot.Stamp(), profileDir)
+		if !interrupted(err) {
+			run.Report.Add(report.Wrap(err, report.StageProfile, userInfo.UserName, "", snapshot.AvatarURL))
+		}
+	}
+
+	if hasLatest && latest.BannerURL == snapshot.BannerURL && latest.BannerFile != "" {
+		snapshot.BannerFile = latest.BannerFile
+	} else if snapshot.BannerURL != "" {
+		snapshot.BannerFile, err = saveProfileImage(ctx, run, user.FullSizeBannerURL(snapshot.BannerURL), "banner", snapshot.Stamp(), profileDir)
+		if !interrupted(err) {
+			run.Report.Add(report.Wrap(err, report.StageProfile, userInfo.UserName, "", snapshot.BannerURL))
+		}
+	}
+
+	// the history copy is archived with the media, the latest one stays
+	// local for the next run to compare against
+	if !hasLatest || !latest.SameProfile(snapshot) {
+		data, err := json.MarshalIndent(snapshot.History(), "", "  ")
+		if err == nil {
+			_, err = run.sink().Put(ctx, profileDir+snapshot.HistoryName(), bytes.NewReader(data), int64(len(data)))
+		}
+		if err != nil && !interrupted(err) {
+			run.Report.Add(report.Wrap(err, report.StageProfile, userInfo.UserName, "", ""))
+		}
+	}
+	if err := user.SaveSnapshot(userInfo.SaveDir, snapshot); err != nil {
+		run.Report.Add(report.Wrap(err, report.StageProfile, userInfo.UserName, "", ""))
+	}
+}
@@ -0,0 +1,90 @@
+package download
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+
+	"twitterDownload/pkg/collector"
+	"twitterDownload/pkg/config"
+	"twitterDownload/pkg/progress"
+	"twitterDownload/pkg/report"
+	"twitterDownload/pkg/sink"
+	"twitterDownload/pkg/storage"
+)
+
+// Run carries what every download of one run shares: the error report, the
+// sink that receives progress events, how the API is reached, the ledger
+// and how timelines are synced and files named
+type Run struct {
+	Report *report.Report
+	Events progress.Sink
+	// API is how users, timelines and media are requested
+	API collector.API
+	// Ledger records every downloaded media URL and where it was stored
+	Ledger storage.URLStorage
+	// Sink stores media and profile images, the local disk when nil
+	Sink sink.Sink
+	// ImageFormats are extra original image formats tried before an image's
+	// own one
+	ImageFormats []string
+	// NameTemplate names saved files, config.DefaultNameTemplate when empty
+	NameTemplate string
+	// RecordCSV is the file media rows are appended to, none when empty
+	RecordCSV string
+	// SyncMode defaults to SyncIncremental
+	SyncMode SyncMode
+	// KnownPages ends an incremental crawl after this many consecutive
+	// pages without new media, DefaultKnownPages when zero
+	KnownPages int
+	// Filters limits which media are downloaded
+	Filters config.Filters
+	// Sources are the timelines crawled for each user, SourceMedia when empty
+	Sources []Source
+	// DryRun crawls and reports MediaPlanned events with estimated sizes
+	// instead of downloading. Nothing is written: no media, profile, ledger,
+	// CSV or sync state.
+	DryRun bool
+	// Thumbnails is the longest edge in pixels of the JPEG thumbnails made
+	// for each saved media item, none are made when zero
+	Thumbnails int
+
+	// indexMu serialises appends to the media.jsonl sidecars
+	indexMu sync.Mutex
+}
+
+// NewRun creates a Run with an empty report, an in-memory ledger and no
+// event sink
+func NewRun() *Run {
+	return &Run{Report: report.NewReport(), Ledger: storage.NewURLStore("")}
+}
+
+// interrupted reports whether err only says that the run was cancelled,
+// which is not a failure of the item being worked on
+func interrupted(err error) bool {
+	return errors.Is(err, context.Canceled)
+}
+
+// saveLedger writes the ledger to disk. A sink that must be closed, like a
+// bundle, keeps its files in a temporary archive until then, so whoever
+// closes it saves the ledger afterwards instead.
+func (r *Run) saveLedger() error {
+	if _, ok := r.sink().(io.Closer); ok {
+		return nil
+	}
+	return r.Ledger.SaveToFile()
+}
+
+func (r *Run) sink() sink.Sink {
+	if r.Sink == nil {
+		return sink.Local{}
+	}
+	return r.Sink
+}
+
+func (r *Run) emit(e progress.Event) {
+	if r.Events != nil {
+		r.Events.Emit(e)
+	}
+}
@@ -0,0 +1,116 @@
+package download
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+
+	"twitterDownload/pkg/progress"
+	"twitterDownload/pkg/report"
+	"twitterDownload/pkg/sink"
+	"twitterDownload/pkg/user"
+	"twitterDownload/pkg/utils"
+)
+
+// progressInterval is how many bytes are written between FileProgress events
+const progressInterval = 256 * 1024
+
+// progressWriter emits FileProgress events while a file is written
+type progressWriter struct {
+	run     *Run
+	user    string
+	url     string
+	total   int64
+	n       int64
+	emitted int64
+}
+
+func (p *progressWriter) Write(b []byte) (int, error) {
+	p.n += int64(len(b))
+	if p.n-p.emitted >= progressInterval {
+		p.emitted = p.n
+		p.run.emit(progress.Event{Kind: progress.FileProgress, User: p.user, URL: p.url, Bytes: p.n, Total: p.total})
+	}
+	return len(b), nil
+}
+
+// downloadStream streams a video or audio file straight to disk instead of
+// buffering it in memory, reporting per-file progress. The body goes to a
+// .part file that is renamed once complete.
+func downloadStream(ctx context.Context, run *Run, mediaUrl string, ledgerKey string, name string, userInfo *user.UserInfo) (int64, error) {
+	var lastErr error
+	for retryCount := 0; retryCount <= 3; retryCount++ {
+		n, retry, err := streamOnce(ctx, run, mediaUrl, ledgerKey, name, userInfo)
+		if err == nil || !retry || ctx.Err() != nil {
+			return n, err
+		}
+		lastErr = err
+		slog.Warn("download media failed", "url", mediaUrl, "err", err, "retry", retryCount)
+	}
+	return 0, lastErr
+}
+
+// streamOnce makes one attempt; retry reports whether a failure is worth
+// another attempt
+func streamOnce(ctx context.Context, run *Run, mediaUrl string, ledgerKey string, name string, userInfo *user.UserInfo) (n int64, retry bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, mediaUrl, nil)
+	if err != nil {
+		return 0, false, report.Wrap(err, report.StageDownload, "", "", mediaUrl)
+	}
+	resp, err := run.API.HTTPClient().Do(req)
+	if err != nil {
+		return 0, true, report.Wrap(err, report.StageDownload, "", "", mediaUrl)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		// 4xx 表示该地址不存在或无权访问，重试没有意义
+		retry = resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+		return 0, retry, report.Wrap(fmt.Errorf("unexpected status %s", resp.Status), report.StageDownload, "", "", mediaUrl)
+	}
+
+	body := bufio.NewReader(resp.Body)
+	head, _ := body.Peek(512)
+	kind, ext := utils.DetectMedia(resp.Header.Get("Content-Type"), head)
+	switch kind {
+	case "unknown":
+		return 0, false, errUnsupported
+	case "hls":
+		n, err := downloadHLS(ctx, run, mediaUrl, name, userInfo)
+		return n, false, err
+	}
+
+	dir := userInfo.SaveDir
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return 0, false, report.Wrap(err, report.StageSave, "", "", mediaUrl)
+	}
+	fileName := name + ext
+	partPath := dir + fileName + ".part"
+	file, err := os.Create(partPath)
+	if err != nil {
+		return 0, false, report.Wrap(err, report.StageSave, "", "", mediaUrl)
+	}
+
+	run.emit(progress.Event{Kind: progress.FileStarted, User: userInfo.UserName, URL: mediaUrl, Total: resp.ContentLength})
+	pw := &progressWriter{run: run, user: userInfo.UserName, url: mediaUrl, total: resp.ContentLength}
+	n, err = io.Copy(io.MultiWriter(file, pw), body)
+	closeErr := file.Close()
+	run.emit(progress.Event{Kind: progress.FileDone, User: userInfo.UserName, URL: mediaUrl, Bytes: n})
+	if err != nil {
+		os.Remove(partPath)
+		return 0, true, report.Wrap(err, report.StageDownload, "", "", mediaUrl)
+	}
+	if closeErr != nil {
+		os.Remove(partPath)
+		return 0, false, report.Wrap(closeErr, report.StageSave, "", "", mediaUrl)
+	}
+	location, err := sink.PutFile(ctx, run.sink(), partPath, dir+fileName)
+	if err != nil {
+		return 0, false, report.Wrap(err, report.StageSave, "", "", mediaUrl)
+	}
+	run.Ledger.AddLocation(ledgerKey, location)
+	return n, false, nil
+}
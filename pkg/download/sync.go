@@ -0,0 +1,93 @@
+package download
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SyncMode decides where a crawl of a user's timeline starts and stops
+type SyncMode string
+
+const (
+	// SyncFull walks the entire timeline from the newest page
+	SyncFull SyncMode = "full"
+	// SyncIncremental walks from the newest page until it reaches the stored
+	// high-water tweet or KnownPages consecutive pages without new media
+	SyncIncremental SyncMode = "incremental"
+	// SyncBackfill resumes from the oldest cursor reached so far
+	SyncBackfill SyncMode = "backfill"
+)
+
+// DefaultKnownPages is how many consecutive fully downloaded pages end an
+// incremental crawl when no high-water tweet is stored
+const DefaultKnownPages = 3
+
+// syncStateFile is the per-user file holding the storage.SyncState
+const syncStateFile = "sync.json"
+
+// ParseSyncMode validates a mode name, defaulting to incremental when empty
+func ParseSyncMode(s string) (SyncMode, error) {
+	switch mode := SyncMode(strings.ToLower(strings.TrimSpace(s))); mode {
+	case "":
+		return SyncIncremental, nil
+	case SyncFull, SyncIncremental, SyncBackfill:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("unknown sync mode %q, want full, incremental or backfill", s)
+	}
+}
+
+// Source is a timeline of a user that media is collected from
+type Source string
+
+const (
+	// SourceMedia is the user's own media tab
+	SourceMedia Source = "media"
+	// SourceLikes is the tweets the user liked, saved under likes/
+	SourceLikes Source = "likes"
+)
+
+// ParseSource validates a timeline name
+func ParseSource(s string) (Source, error) {
+	switch source := Source(strings.ToLower(strings.TrimSpace(s))); source {
+	case SourceMedia, SourceLikes:
+		return source, nil
+	default:
+		return "", fmt.Errorf("unknown timeline source %q, want media or likes", s)
+	}
+}
+
+// endpoint is the GraphQL query ID and operation of the timeline
+func (s Source) endpoint() string {
+	if s == SourceLikes {
+		return "eSSNbhECHHWWALkkQq-YTA/Likes"
+	}
+	return "aQQLnkexAl5z9ec_UgbEIA/UserMedia"
+}
+
+// subDir is where the timeline's media and sync state go inside SaveDir
+func (s Source) subDir() string {
+	if s == SourceLikes {
+		return "likes/"
+	}
+	return ""
+}
+
+// OrderedByTweet reports whether the timeline is sorted by tweet ID, newest
+// first. Likes are sorted by when they were liked, so neither the high-water
+// tweet nor the since date can end their crawl early.
+func (s Source) OrderedByTweet() bool {
+	return s != SourceLikes
+}
+
+// pageResult is what one crawled timeline page tells the sync loop
+type pageResult struct {
+	// tweets counts the tweets on the page, zero once the timeline ended
+	tweets        int
+	allKnown      bool
+	nextCursor    string
+	newestTweetId string
+	oldestTweetId string
+	// pastSince is set once a tweet older than the since filter was seen
+	pastSince bool
+}
@@ -0,0 +1,129 @@
+package download
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"twitterDownload/pkg/report"
+	"twitterDownload/pkg/thumb"
+	"twitterDownload/pkg/user"
+)
+
+// ThumbDirName is the folder next to the media that holds their thumbnails
+const ThumbDirName = ".thumbs"
+
+// thumbSuffix turns a media URL into the ledger key of its thumbnail.
+// Media URLs are stored without a fragment, so the keys never collide.
+const thumbSuffix = "#thumb"
+
+// ThumbKey is the ledger key a media URL's thumbnail is recorded under
+func ThumbKey(mediaUrl string) string {
+	return mediaUrl + thumbSuffix
+}
+
+// thumbSource returns the image a thumbnail of item is made from: the saved
+// file of a photo when it is on the local disk, otherwise the photo itself
+// or the poster frame the API gives for videos and GIFs
+func thumbSource(ctx context.Context, run *Run, item MediaItem, location string) ([]byte, error) {
+	if item.Type == "photo" {
+		if data, err := os.ReadFile(location); err == nil {
+			return data, nil
+		}
+	}
+	sourceUrl := item.MediaURL
+	if item.Type == "photo" {
+		sourceUrl = item.URL
+	}
+	if sourceUrl == "" {
+		return nil, errors.New("no image to make a thumbnail from")
+	}
+	data, _, err := fetchBytes(ctx, run.API, sourceUrl)
+	return data, err
+}
+
+// saveThumbnail stores a thumbnail of a saved media item as
+// <dir>.thumbs/<name>.jpg, records it in the ledger and returns its location
+func saveThumbnail(ctx context.Context, run *Run, item MediaItem, location string, name string, dir string) (string, error) {
+	data, err := thumbSource(ctx, run, item, location)
+	if err != nil {
+		return "", err
+	}
+	if data, err = thumb.Make(data, run.Thumbnails); err != nil {
+		return "", err
+	}
+	thumbLocation, err := run.sink().Put(ctx, dir+ThumbDirName+"/"+name+".jpg", bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return "", err
+	}
+	run.Ledger.AddLocation(ThumbKey(EventURL(item)), thumbLocation)
+	return thumbLocation, nil
+}
+
+// addThumbnail sets entry.Thumb when run.Thumbnails is enabled. Images the
+// standard decoders cannot read, e.g. WebP, are left without one.
+func addThumbnail(ctx context.Context, run *Run, entry *IndexEntry, name string, userInfo *user.UserInfo) {
+	if run.Thumbnails <= 0 {
+		return
+	}
+	location, err := saveThumbnail(ctx, run, entry.MediaItem, entry.Location, name, userInfo.SaveDir)
+	switch {
+	case errors.Is(err, image.ErrFormat):
+		slog.Debug("no thumbnail for image format", "user", userInfo.UserName, "url", entry.URL)
+	case err != nil && !interrupted(err):
+		run.Report.Add(report.Wrap(err, report.StageThumbnail, userInfo.UserName, entry.TweetId, entry.URL))
+	case err == nil:
+		entry.Thumb = location
+	}
+}
+
+// MakeThumbnails adds the missing thumbnails of the media listed in the
+// media.jsonl index of dir, e.g. for folders archived before thumbnails
+// were enabled, and returns how many were made. Each new thumbnail is
+// appended to the index and failures go to run.Report.
+func MakeThumbnails(ctx context.Context, run *Run, dir string) (int, error) {
+	entries, err := ReadIndex(dir)
+	if err != nil {
+		return 0, err
+	}
+	userInfo := &user.UserInfo{SaveDir: dir + string(os.PathSeparator)}
+	made := 0
+	for _, entry := range entries {
+		if ctx.Err() != nil {
+			return made, ctx.Err()
+		}
+		if entry.Thumb != "" && run.Ledger.URLExists(ThumbKey(EventURL(entry.MediaItem))) {
+			continue
+		}
+		userInfo.UserName = entry.User
+		addThumbnail(ctx, run, &entry, thumbName(entry), userInfo)
+		if entry.Thumb == "" {
+			continue
+		}
+		if err := run.addToIndex(dir, entry); err != nil {
+			return made, err
+		}
+		made++
+	}
+	return made, nil
+}
+
+// thumbName names the thumbnail of an indexed entry after its saved file
+func thumbName(entry IndexEntry) string {
+	name := DefaultName(filepath.ToSlash(entry.Location))
+	if name == "" || name == "." {
+		name = DefaultName(entry.URL)
+	}
+	return name
+}
+
+// IsThumbKey reports whether a ledger key records a thumbnail rather than
+// a media file
+func IsThumbKey(key string) bool {
+	return strings.HasSuffix(key, thumbSuffix)
+}
@@ -0,0 +1,130 @@
+package download
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"twitterDownload/pkg/collector"
+)
+
+func encodeImage(t *testing.T, w, h int, enc func(*bytes.Buffer, image.Image) error) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := enc(&buf, image.NewRGBA(image.Rect(0, 0, w, h))); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func decodeSize(t *testing.T, path string) image.Point {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	config, err := jpeg.DecodeConfig(f)
+	if err != nil {
+		t.Fatalf("%s: %v", path, err)
+	}
+	return image.Pt(config.Width, config.Height)
+}
+
+func TestMakeThumbnails(t *testing.T) {
+	poster := encodeImage(t, 400, 800, func(b *bytes.Buffer, img image.Image) error { return jpeg.Encode(b, img, nil) })
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Write(poster)
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	photo := filepath.Join(dir, "photo.jpg")
+	if err := os.WriteFile(photo, encodeImage(t, 640, 480, func(b *bytes.Buffer, img image.Image) error { return png.Encode(b, img) }), 0644); err != nil {
+		t.Fatal(err)
+	}
+	webp := filepath.Join(dir, "pic.webp")
+	if err := os.WriteFile(webp, []byte("RIFF\x00\x00\x00\x00WEBPVP8 "), 0644); err != nil {
+		t.Fatal(err)
+	}
+	entries := []IndexEntry{
+		{MediaItem: MediaItem{TweetId: "1", Type: "photo", URL: "https://pbs.twimg.com/media/photo.jpg"}, Location: photo},
+		// a video gets the poster frame the API gives for it
+		{MediaItem: MediaItem{TweetId: "2", Type: "video", MediaURL: srv.URL + "/poster.jpg", URL: "https://video.twimg.com/v/clip.mp4?tag=12"}, Location: filepath.Join(dir, "clip.mp4")},
+		{MediaItem: MediaItem{TweetId: "3", Type: "photo", URL: "https://pbs.twimg.com/media/pic.webp"}, Location: webp},
+		// already has one
+		{MediaItem: MediaItem{TweetId: "4", Type: "video", MediaURL: srv.URL + "/done.jpg", URL: "https://video.twimg.com/v/done.mp4"}, Location: filepath.Join(dir, "done.mp4"), Thumb: filepath.Join(dir, ThumbDirName, "done.jpg")},
+	}
+	if err := AppendIndex(dir, entries...); err != nil {
+		t.Fatal(err)
+	}
+
+	run := NewRun()
+	run.API = collector.API{Cookie: "ct0=token", Client: srv.Client(), Concurrency: 1}
+	run.Thumbnails = 100
+	run.Ledger.AddLocation(ThumbKey("https://video.twimg.com/v/done.mp4"), entries[3].Thumb)
+
+	made, err := MakeThumbnails(context.Background(), run, dir)
+	if err != nil || made != 2 {
+		t.Fatalf("made %d thumbnails, %v, want 2", made, err)
+	}
+	if run.Report.Failed() {
+		t.Errorf("errors reported: %v", run.Report.Errors())
+	}
+	if n := requests.Load(); n != 1 {
+		t.Errorf("%d images fetched, want only the poster of the video without a thumbnail", n)
+	}
+
+	photoThumb := filepath.Join(dir, ThumbDirName, "photo.jpg")
+	clipThumb := filepath.Join(dir, ThumbDirName, "clip.jpg")
+	if got := decodeSize(t, photoThumb); got != image.Pt(100, 75) {
+		t.Errorf("photo thumbnail of %v, want 100x75", got)
+	}
+	if got := decodeSize(t, clipThumb); got != image.Pt(50, 100) {
+		t.Errorf("video thumbnail of %v, want 50x100", got)
+	}
+
+	// the ledger keys drop the query of the media URL
+	for url, want := range map[string]string{
+		"https://pbs.twimg.com/media/photo.jpg": photoThumb,
+		"https://video.twimg.com/v/clip.mp4":    clipThumb,
+		"https://pbs.twimg.com/media/pic.webp":  "",
+	} {
+		if got := run.Ledger.Location(ThumbKey(url)); got != want {
+			t.Errorf("ledger thumbnail of %s is %q, want %q", url, got, want)
+		}
+	}
+	indexed, err := ReadIndex(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	thumbs := make(map[string]string)
+	for _, entry := range indexed {
+		thumbs[entry.TweetId] = entry.Thumb
+	}
+	want := map[string]string{"1": photoThumb, "2": clipThumb, "3": "", "4": entries[3].Thumb}
+	for id, thumb := range want {
+		if thumbs[id] != thumb {
+			t.Errorf("media.jsonl thumbnail of tweet %s is %q, want %q", id, thumbs[id], thumb)
+		}
+	}
+
+	// a second run finds nothing left to do
+	if made, err := MakeThumbnails(context.Background(), run, dir); err != nil || made != 0 {
+		t.Errorf("second run made %d thumbnails, %v", made, err)
+	}
+	if n := requests.Load(); n != 1 {
+		t.Errorf("second run fetched %d images", n-1)
+	}
+}
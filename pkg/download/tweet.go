@@ -0,0 +1,133 @@
+package download
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+
+	"twitterDownload/pkg/collector"
+	"twitterDownload/pkg/progress"
+	"twitterDownload/pkg/report"
+	"twitterDownload/pkg/user"
+	"twitterDownload/pkg/utils"
+
+	"github.com/gocolly/colly"
+	"github.com/tidwall/gjson"
+)
+
+// ErrTweetNotFound is returned when a tweet is deleted, withheld or not
+// visible to the logged-in account
+var ErrTweetNotFound = errors.New("tweet not found")
+
+func generateTweetUrl(api collector.API, tweetId string) string {
+	variables, _ := json.Marshal(map[string]interface{}{
+		"tweetId":                tweetId,
+		"withCommunity":          false,
+		"includePromotedContent": false,
+		"withVoice":              false,
+	})
+	features, _ := json.Marshal(map[string]interface{}{
+		"creator_subscriptions_tweet_preview_api_enabled":                         true,
+		"tweetypie_unmention_optimization_enabled":                                true,
+		"responsive_web_edit_tweet_api_enabled":                                   true,
+		"graphql_is_translatable_rweb_tweet_is_translatable_enabled":              true,
+		"view_counts_everywhere_api_enabled":                                      true,
+		"longform_notetweets_consumption_enabled":                                 true,
+		"responsive_web_twitter_article_tweet_consumption_enabled":                false,
+		"tweet_awards_web_tipping_enabled":                                        false,
+		"freedom_of_speech_not_reach_fetch_enabled":                               true,
+		"standardized_nudges_misinfo":                                             true,
+		"tweet_with_visibility_results_prefer_gql_limited_actions_policy_enabled": true,
+		"longform_notetweets_rich_text_read_enabled":                              true,
+		"longform_notetweets_inline_media_enabled":                                true,
+		"responsive_web_graphql_exclude_directive_enabled":                        true,
+		"verified_phone_label_enabled":                                            false,
+		"responsive_web_media_download_video_enabled":                             false,
+		"responsive_web_graphql_skip_user_profile_image_extensions_enabled":       false,
+		"responsive_web_graphql_timeline_navigation_enabled":                      true,
+		"responsive_web_enhance_cards_enabled":                                    false,
+	})
+
+	queryParams := url.Values{}
+	queryParams.Add("variables", string(variables))
+	queryParams.Add("features", string(features))
+	return api.URL("/i/api/graphql/2ICDjqPd81tulZcYrtpTuQ/TweetResultByRestId", queryParams)
+}
+
+// parseTweetResponse reads the media and author of a TweetResultByRestId
+// response
+func parseTweetResponse(body []byte) (user.UserInfo, []MediaItem, error) {
+	result := gjson.GetBytes(body, "data.tweetResult.result")
+	if result.Get("__typename").String() == "TweetWithVisibilityResults" {
+		result = result.Get("tweet")
+	}
+	if !result.Get("legacy").Exists() {
+		return user.UserInfo{}, nil, ErrTweetNotFound
+	}
+
+	var legacy utils.Legacy
+	if err := json.Unmarshal([]byte(result.Get("legacy").Raw), &legacy); err != nil {
+		return user.UserInfo{}, nil, err
+	}
+	if legacy.TweetID == "" {
+		legacy.TweetID = result.Get("rest_id").String()
+	}
+	author := user.ParseUserResult(result.Get("core.user_results.result"))
+	return author, newMediaItems(legacy), nil
+}
+
+// FetchTweet looks a tweet up by ID and returns its author and media
+func FetchTweet(ctx context.Context, api collector.API, tweetId string) (user.UserInfo, []MediaItem, error) {
+	c := collector.NewCollector(ctx, api)
+	var (
+		author   user.UserInfo
+		items    []MediaItem
+		fetchErr error
+	)
+	c.OnResponse(func(r *colly.Response) {
+		author, items, fetchErr = parseTweetResponse(r.Body)
+	})
+	c.OnError(func(r *colly.Response, err error) {
+		fetchErr = err
+	})
+
+	reqUrl := generateTweetUrl(api, tweetId)
+	if err := c.Visit(reqUrl); err != nil {
+		return author, nil, report.Wrap(err, report.StageTimeline, "", tweetId, reqUrl)
+	}
+	c.Wait()
+	if fetchErr == nil && ctx.Err() != nil {
+		fetchErr = ctx.Err()
+	}
+	if fetchErr != nil {
+		return author, nil, report.Wrap(fmt.Errorf("tweet %s: %w", tweetId, fetchErr), report.StageTimeline, author.UserName, tweetId, reqUrl)
+	}
+	return author, items, nil
+}
+
+// DownloadItems downloads media items into userInfo.SaveDir outside of a
+// timeline crawl, e.g. the media of a single tweet, and saves the ledger
+// unless the sink is closed later.
+// A dry run only plans them.
+func DownloadItems(ctx context.Context, run *Run, items []MediaItem, userInfo *user.UserInfo) {
+	tasks := make([]mediaTask, 0, len(items))
+	for _, item := range items {
+		tasks = append(tasks, item.task(run, userInfo))
+	}
+	run.emit(progress.Event{Kind: progress.MediaDiscovered, User: userInfo.UserName, Count: len(tasks)})
+	downloadMediaUrls(ctx, run, tasks, userInfo)
+	if run.DryRun {
+		return
+	}
+	if err := run.saveLedger(); err != nil {
+		run.Report.Add(report.Wrap(err, report.StageLedger, userInfo.UserName, "", ""))
+	}
+}
+
+// EventURL is the URL progress events use for an item, letting a sink match
+// MediaDone, MediaSkipped and MediaFailed events to the items it asked for
+func EventURL(item MediaItem) string {
+	return utils.TrimURLQueryAndHash(item.URL)
+}
@@ -0,0 +1,134 @@
+package export
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// Format is an export file format
+type Format string
+
+const (
+	CSV   Format = "csv"
+	JSONL Format = "jsonl"
+)
+
+// ParseFormat checks a format name
+func ParseFormat(s string) (Format, error) {
+	switch f := Format(s); f {
+	case CSV, JSONL:
+		return f, nil
+	}
+	return "", fmt.Errorf("export format %q is not csv or jsonl", s)
+}
+
+// Ext is the file extension of the format
+func (f Format) Ext() string {
+	return "." + string(f)
+}
+
+// Exporter writes records in one format
+type Exporter interface {
+	Write(r Record) error
+	// Close finishes the file. Nothing is left behind when an earlier
+	// Write failed.
+	Close() error
+	// Abort drops the file, keeping what was at its path before
+	Abort() error
+}
+
+// Create starts an export to path. The file is written next to it with a
+// .part suffix and only replaces path on Close, so a failed export keeps
+// the previous one.
+func Create(format Format, path string) (Exporter, error) {
+	f, err := os.Create(path + ".part")
+	if err != nil {
+		return nil, err
+	}
+	out := &file{f: f, path: path}
+	switch format {
+	case CSV:
+		e := &csvExporter{file: out, w: csv.NewWriter(f)}
+		if err := e.w.Write(columns); err != nil {
+			return nil, out.finish(err)
+		}
+		return e, nil
+	case JSONL:
+		w := bufio.NewWriter(f)
+		return &jsonlExporter{file: out, w: w, enc: json.NewEncoder(w)}, nil
+	}
+	return nil, out.finish(fmt.Errorf("export format %q is not supported", format))
+}
+
+// file is the .part file of an export
+type file struct {
+	f    *os.File
+	path string
+	err  error
+}
+
+// finish closes the file and moves it to its path, or removes it when err
+// or an earlier error is set
+func (o *file) finish(err error) error {
+	err = errors.Join(o.err, err, o.f.Close())
+	if err != nil {
+		os.Remove(o.f.Name())
+		return err
+	}
+	return os.Rename(o.f.Name(), o.path)
+}
+
+func (o *file) Abort() error {
+	return errors.Join(o.f.Close(), os.Remove(o.f.Name()))
+}
+
+type csvExporter struct {
+	*file
+	w *csv.Writer
+}
+
+func (e *csvExporter) Write(r Record) error {
+	values := r.values()
+	row := make([]string, len(values))
+	for i, v := range values {
+		switch v := v.(type) {
+		case string:
+			row[i] = v
+		case int64:
+			row[i] = strconv.FormatInt(v, 10)
+		}
+	}
+	if err := e.w.Write(row); err != nil {
+		e.err = err
+		return err
+	}
+	return nil
+}
+
+func (e *csvExporter) Close() error {
+	e.w.Flush()
+	return e.finish(e.w.Error())
+}
+
+type jsonlExporter struct {
+	*file
+	w   *bufio.Writer
+	enc *json.Encoder
+}
+
+func (e *jsonlExporter) Write(r Record) error {
+	if err := e.enc.Encode(r); err != nil {
+		e.err = err
+		return err
+	}
+	return nil
+}
+
+func (e *jsonlExporter) Close() error {
+	return e.finish(e.w.Flush())
+}
@@ -0,0 +1,164 @@
+package export
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var testRecords = []Record{
+	{
+		TweetId: "1790000000000000001", TweetDate: "2024-05-13 10:00:00", TweetURL: "https://x.com/someone/status/1790000000000000001",
+		TweetText: "first line, with a comma\nsecond \"quoted\" line 中文", User: "someone", AuthorId: "123", Source: "media",
+		MediaIndex: 1, MediaKey: "3_1790000000000000010", MediaType: "photo", Width: 1200, Height: 800,
+		URL: "https://pbs.twimg.com/media/GMAXMobaYAAk3Ab.jpg", Location: "123/GMAXMobaYAAk3Ab.jpg", LocalPath: "123/GMAXMobaYAAk3Ab.jpg",
+		Bytes: 123456, SHA256: "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08", Saved: "2024-05-13T10:05:00Z",
+	},
+	// a download record entry without a sidecar: most fields are unknown
+	{URL: "https://video.twimg.com/ext_tw_video/1/pu/vid/720x1280/a.mp4", Location: "123.zip!a.mp4"},
+}
+
+func export(t *testing.T, format Format) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "export"+format.Ext())
+	e, err := Create(format, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range testRecords {
+		if err := e.Write(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path + ".part"); !os.IsNotExist(err) {
+		t.Errorf("%s.part left behind: %v", path, err)
+	}
+	return path
+}
+
+func TestCSV(t *testing.T) {
+	f, err := os.Open(export(t, CSV))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	rows, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 3 {
+		t.Fatalf("%d rows, want a header and 2 records", len(rows))
+	}
+	if got := strings.Join(rows[0], ","); got != "tweet_id,tweet_date,tweet_url,tweet_text,user,author_id,source,media_index,media_key,media_type,width,height,duration_ms,bitrate,url,location,local_path,bytes,sha256,saved" {
+		t.Errorf("header %s", got)
+	}
+	first := map[string]string{}
+	for i, name := range rows[0] {
+		first[name] = rows[1][i]
+	}
+	want := map[string]string{
+		"tweet_text":  testRecords[0].TweetText,
+		"media_index": "1",
+		"width":       "1200",
+		"duration_ms": "",
+		"bytes":       "123456",
+		"saved":       "2024-05-13T10:05:00Z",
+	}
+	for name, value := range want {
+		if first[name] != value {
+			t.Errorf("%s = %q, want %q", name, first[name], value)
+		}
+	}
+	if got := strings.Join(rows[2], ","); got != ",,,,,,,,,,,,,,https://video.twimg.com/ext_tw_video/1/pu/vid/720x1280/a.mp4,123.zip!a.mp4,,,," {
+		t.Errorf("record without a sidecar %s", got)
+	}
+}
+
+func TestJSONL(t *testing.T) {
+	f, err := os.Open(export(t, JSONL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	var lines []string
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if len(lines) != len(testRecords) {
+		t.Fatalf("%d lines, want %d", len(lines), len(testRecords))
+	}
+	for i, line := range lines {
+		var got Record
+		if err := json.Unmarshal([]byte(line), &got); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, testRecords[i]) {
+			t.Errorf("line %d is %+v, want %+v", i+1, got, testRecords[i])
+		}
+		// every line has every column, in the order of the CSV header
+		var fields map[string]any
+		if err := json.Unmarshal([]byte(line), &fields); err != nil {
+			t.Fatal(err)
+		}
+		if len(fields) != len(columns) {
+			t.Errorf("line %d has %d fields, want %d", i+1, len(fields), len(columns))
+		}
+		last := -1
+		for _, c := range columns {
+			at := strings.Index(line, `"`+c+`":`)
+			if at < last {
+				t.Errorf("line %d: %s out of order", i+1, c)
+			}
+			last = at
+		}
+	}
+}
+
+func TestAbortKeepsPreviousExport(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "export.csv")
+	if err := os.WriteFile(path, []byte("previous"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	e, err := Create(CSV, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Write(testRecords[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Abort(); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "previous" {
+		t.Errorf("export holds %q after an abort", data)
+	}
+	if _, err := os.Stat(path + ".part"); !os.IsNotExist(err) {
+		t.Errorf("%s.part left behind: %v", path, err)
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		ext  string
+	}{{"csv", ".csv"}, {"jsonl", ".jsonl"}} {
+		f, err := ParseFormat(tt.name)
+		if err != nil || f.Ext() != tt.ext {
+			t.Errorf("%s: format %q, extension %q, %v", tt.name, f, f.Ext(), err)
+		}
+	}
+	for _, name := range []string{"parquet", "sqlite"} {
+		if _, err := ParseFormat(name); err == nil {
+			t.Errorf("%s accepted", name)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+// Package export writes the media of an archive as CSV or JSON Lines.
+// Exports are built from the media.jsonl sidecars and the download record,
+// so they can be regenerated in either format at any time without crawling
+// again.
+package export
+
+import (
+	"os"
+	"time"
+
+	"twitterDownload/pkg/download"
+)
+
+// Record is one exported media file. JSON Lines use the same snake_case
+// names as the CSV header, and every line carries
+// every field, so the output loads into columnar tools such as Parquet
+// converters with a stable schema.
+type Record struct {
+	TweetId    string `json:"tweet_id"`
+	TweetDate  string `json:"tweet_date"`
+	TweetURL   string `json:"tweet_url"`
+	TweetText  string `json:"tweet_text"`
+	User       string `json:"user"`
+	AuthorId   string `json:"author_id"`
+	Source     string `json:"source"`
+	MediaIndex int    `json:"media_index"`
+	MediaKey   string `json:"media_key"`
+	MediaType  string `json:"media_type"`
+	Width      int    `json:"width"`
+	Height     int    `json:"height"`
+	DurationMs int    `json:"duration_ms"`
+	Bitrate    int    `json:"bitrate"`
+	URL        string `json:"url"`
+	// Location is where the file went, LocalPath the same when that is a
+	// file on this disk
+	Location  string `json:"location"`
+	LocalPath string `json:"local_path"`
+	Bytes     int64  `json:"bytes"`
+	SHA256    string `json:"sha256"`
+	// Saved is when the file was downloaded, RFC 3339
+	Saved string `json:"saved"`
+}
+
+// columns are the CSV header, in the order of Record.values
+var columns = []string{
+	"tweet_id",
+	"tweet_date",
+	"tweet_url",
+	"tweet_text",
+	"user",
+	"author_id",
+	"source",
+	"media_index",
+	"media_key",
+	"media_type",
+	"width",
+	"height",
+	"duration_ms",
+	"bitrate",
+	"url",
+	"location",
+	"local_path",
+	"bytes",
+	"sha256",
+	"saved",
+}
+
+// values lists the fields in column order, nil for unknown ones
+func (r Record) values() []any {
+	text := func(s string) any {
+		if s == "" {
+			return nil
+		}
+		return s
+	}
+	number := func(n int64) any {
+		if n == 0 {
+			return nil
+		}
+		return n
+	}
+	return []any{
+		text(r.TweetId), text(r.TweetDate), text(r.TweetURL), text(r.TweetText),
+		text(r.User), text(r.AuthorId), text(r.Source), number(int64(r.MediaIndex)),
+		text(r.MediaKey), text(r.MediaType), number(int64(r.Width)), number(int64(r.Height)),
+		number(int64(r.DurationMs)), number(int64(r.Bitrate)), text(r.URL), text(r.Location),
+		text(r.LocalPath), number(r.Bytes), text(r.SHA256), text(r.Saved),
+	}
+}
+
+// FromEntry turns a sidecar entry into a record
+func FromEntry(entry download.IndexEntry) Record {
+	r := FromLedger(download.EventURL(entry.MediaItem), entry.Location)
+	r.TweetId, r.TweetDate, r.TweetURL, r.TweetText = entry.TweetId, entry.TweetDate, entry.TweetURL, entry.TweetText
+	r.User, r.AuthorId, r.Source = entry.User, entry.AuthorId, string(entry.Source)
+	r.MediaIndex, r.MediaKey, r.MediaType = entry.Index, entry.MediaKey, entry.Type
+	r.Width, r.Height, r.DurationMs, r.Bitrate = entry.Width, entry.Height, entry.DurationMs, entry.Bitrate
+	r.Bytes, r.SHA256 = entry.Bytes, entry.SHA256
+	if !entry.Saved.IsZero() {
+		r.Saved = entry.Saved.Format(time.RFC3339)
+	}
+	return r
+}
+
+// FromLedger is the record of a download record entry that no sidecar
+// lists, e.g. one saved before sidecars were written: only the URL and
+// where the file went are known
+func FromLedger(url string, location string) Record {
+	r := Record{URL: url, Location: location}
+	if LocalFile(location) {
+		r.LocalPath = location
+	}
+	return r
+}
+
+// LocalFile reports whether location is a file on the local disk rather
+// than a remote object or an archive entry
+func LocalFile(location string) bool {
+	if !download.IsLocalLocation(location) {
+		return false
+	}
+	info, err := os.Stat(location)
+	return err == nil && info.Mode().IsRegular()
+}
@@ -0,0 +1,219 @@
+// Package gallery builds static HTML pages to browse an archive offline:
+// one page per user folder with the media, tweet text and dates, and an
+// index page linking them.
+package gallery
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"twitterDownload/pkg/download"
+	"twitterDownload/pkg/user"
+)
+
+// PageName is the file a user's gallery is written to
+const PageName = "index.html"
+
+// Item is one media item shown in a gallery
+type Item struct {
+	TweetId  string
+	TweetURL string
+	Date     string
+	Text     string
+	Type     string
+	Source   string
+	// Href is the file relative to the page, empty when it is not on the
+	// local disk
+	Href string
+	// Thumb is the picture shown in the grid: the thumbnail when one was
+	// made, else Href for images
+	Thumb string
+	// Location is where the file went when it is not on the local disk
+	Location string
+}
+
+// Page is the gallery of one user folder
+type Page struct {
+	Dir         string
+	UserId      string
+	UserName    string
+	DisplayName string
+	Items       []Item
+	Generated   time.Time
+}
+
+// Load reads the profile, the media.jsonl sidecars of dir and its likes
+// folder and, for folders archived before sidecars were written, the rows
+// of recordCSV that belong to the user
+func Load(dir string, recordCSV string) (Page, error) {
+	page := Page{Dir: dir, UserId: filepath.Base(dir), Generated: time.Now()}
+	snapshot, ok, err := user.LoadLatestSnapshot(dir)
+	if err != nil {
+		return page, err
+	}
+	if ok {
+		page.UserId, page.UserName, page.DisplayName = snapshot.UserId, snapshot.UserName, snapshot.DisplayName
+	}
+
+	for _, sub := range []string{"", "likes"} {
+		entries, err := download.ReadIndex(filepath.Join(dir, sub))
+		if err != nil {
+			return page, err
+		}
+		for _, entry := range entries {
+			page.Items = append(page.Items, fromEntry(dir, entry))
+		}
+	}
+	if len(page.Items) == 0 && page.UserName != "" && recordCSV != "" {
+		if page.Items, err = fromCSV(dir, recordCSV, page.UserName); err != nil {
+			return page, err
+		}
+	}
+
+	sort.SliceStable(page.Items, func(i, j int) bool {
+		if page.Items[i].Date != page.Items[j].Date {
+			return page.Items[i].Date > page.Items[j].Date
+		}
+		return page.Items[i].TweetId > page.Items[j].TweetId
+	})
+	return page, nil
+}
+
+func fromEntry(dir string, entry download.IndexEntry) Item {
+	item := Item{
+		TweetId:  entry.TweetId,
+		TweetURL: entry.TweetURL,
+		Date:     entry.TweetDate,
+		Text:     entry.TweetText,
+		Type:     entry.Type,
+		Source:   string(entry.Source),
+	}
+	if href, ok := localHref(dir, entry.Location); ok {
+		item.Href = href
+	} else {
+		item.Location = entry.Location
+	}
+	if thumb, ok := localHref(dir, entry.Thumb); ok {
+		item.Thumb = thumb
+	} else if item.Type == "photo" {
+		item.Thumb = item.Href
+	}
+	return item
+}
+
+// localHref turns a location into a link relative to dir. Remote http(s)
+// locations are linked as they are; keys of other sinks cannot be linked.
+func localHref(dir string, location string) (string, bool) {
+	if location == "" {
+		return "", false
+	}
+	if u, err := url.Parse(location); err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+		return location, true
+	}
+	if strings.Contains(location, "://") || strings.Contains(location, "!") {
+		return "", false
+	}
+	absDir, err1 := filepath.Abs(dir)
+	absFile, err2 := filepath.Abs(location)
+	if err1 != nil || err2 != nil {
+		return "", false
+	}
+	rel, err := filepath.Rel(absDir, absFile)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return "", false
+	}
+	return (&url.URL{Path: filepath.ToSlash(rel)}).String(), true
+}
+
+// fromCSV lists the user's rows of record.csv, linking the files in dir
+// named after the media URL
+func fromCSV(dir string, recordCSV string, userName string) ([]Item, error) {
+	f, err := os.Open(recordCSV)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	files := make(map[string]string)
+	if names, err := os.ReadDir(dir); err == nil {
+		for _, name := range names {
+			if !name.IsDir() {
+				files[strings.TrimSuffix(name.Name(), filepath.Ext(name.Name()))] = name.Name()
+			}
+		}
+	}
+
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	var items []Item
+	for {
+		row, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			return items, nil
+		}
+		if err != nil {
+			return items, fmt.Errorf("%s: %w", recordCSV, err)
+		}
+		// TweetDate, TweetId, Username, DisplayName, TweetText, TweetURL, MediaType, MediaURL
+		if len(row) < 8 || row[0] == "TweetDate" || row[2] != "@"+userName {
+			continue
+		}
+		item := Item{Date: row[0], TweetId: row[1], Text: row[4], TweetURL: row[5], Type: row[6], Source: "media"}
+		base := path.Base(row[7])
+		if file, ok := files[strings.TrimSuffix(base, path.Ext(base))]; ok {
+			item.Href = (&url.URL{Path: file}).String()
+			if item.Type == "photo" {
+				item.Thumb = item.Href
+			}
+		}
+		items = append(items, item)
+	}
+}
+
+// Write saves the page as index.html in its folder
+func Write(page Page) error {
+	f, err := os.Create(filepath.Join(page.Dir, PageName))
+	if err != nil {
+		return err
+	}
+	err = pageTemplate.Execute(f, page)
+	return errors.Join(err, f.Close())
+}
+
+// WriteIndex saves root/index.html linking the galleries of pages
+func WriteIndex(root string, pages []Page) error {
+	type link struct {
+		Page
+		Href  string
+		Count int
+	}
+	links := make([]link, 0, len(pages))
+	for _, page := range pages {
+		href, ok := localHref(root, filepath.Join(page.Dir, PageName))
+		if !ok {
+			continue
+		}
+		links = append(links, link{Page: page, Href: href, Count: len(page.Items)})
+	}
+	f, err := os.Create(filepath.Join(root, PageName))
+	if err != nil {
+		return err
+	}
+	err = indexTemplate.Execute(f, struct {
+		Users     []link
+		Generated time.Time
+	}{links, time.Now()})
+	return errors.Join(err, f.Close())
+}
@@ -0,0 +1,132 @@
+package gallery
+
+import (
+	"html"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// writeFixture puts the sidecars of testdata into a user folder under root,
+// with the files they list, and returns the folder
+func writeFixture(t *testing.T, root string) string {
+	t.Helper()
+	dir := filepath.Join(root, "123")
+	for fixture, sub := range map[string]string{"media.jsonl": "", "likes.jsonl": "likes"} {
+		data, err := os.ReadFile(filepath.Join("testdata", fixture))
+		if err != nil {
+			t.Fatal(err)
+		}
+		data = []byte(strings.ReplaceAll(string(data), "{dir}", dir))
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, sub, "media.jsonl"), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{"pics/a b#1.jpg", ".thumbs/a b#1.jpg", "b.mp4", "likes/D.mp4"} {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte("media"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+var linkAttr = regexp.MustCompile(`(?:href|src|poster)="([^"]*)"`)
+
+// localLinks returns the links of an HTML page that are not http(s) URLs,
+// unescaped to the file paths they name
+func localLinks(t *testing.T, page string) []string {
+	t.Helper()
+	var links []string
+	for _, m := range linkAttr.FindAllStringSubmatch(page, -1) {
+		u, err := url.Parse(html.UnescapeString(m[1]))
+		if err != nil {
+			t.Fatalf("link %q: %v", m[1], err)
+		}
+		if u.Scheme == "" {
+			links = append(links, u.Path)
+		}
+	}
+	return links
+}
+
+func TestWrite(t *testing.T) {
+	root := t.TempDir()
+	dir := writeFixture(t, root)
+	page, err := Load(dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Items) != 4 || page.Items[0].TweetId != "1790000000000000002" || page.Items[3].Source != "likes" {
+		t.Fatalf("items %+v, want 4, newest first", page.Items)
+	}
+	if err := Write(page); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, PageName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := string(data)
+
+	if strings.Contains(out, "<script>alert") || strings.Contains(out, "<b>bold") {
+		t.Error("tweet text is not escaped")
+	}
+	if !strings.Contains(out, "&lt;script&gt;alert(&#34;hi&#34;)&lt;/script&gt; &amp; &lt;b&gt;bold&lt;/b&gt;") {
+		t.Error("escaped tweet text missing")
+	}
+
+	// every local link names a file relative to the page
+	links := localLinks(t, out)
+	want := map[string]bool{"pics/a b#1.jpg": true, ".thumbs/a b#1.jpg": true, "b.mp4": true, "likes/D.mp4": true}
+	for _, link := range links {
+		if filepath.IsAbs(link) || strings.HasPrefix(link, "..") {
+			t.Errorf("link %q is not relative to the page", link)
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(dir, filepath.FromSlash(link))); err != nil {
+			t.Errorf("link %q: %v", link, err)
+		}
+		delete(want, link)
+	}
+	for link := range want {
+		t.Errorf("no link to %s in %q", link, links)
+	}
+	// a file in a bundle is named, not linked
+	if !strings.Contains(out, "<span>123.zip!C.jpg</span>") {
+		t.Error("bundle location not shown")
+	}
+}
+
+func TestWriteIndex(t *testing.T) {
+	root := t.TempDir()
+	dir := writeFixture(t, root)
+	page, err := Load(dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	page.UserName = "some<one>"
+	if err := WriteIndex(root, []Page{page}); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(root, PageName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := string(data)
+	if links := localLinks(t, out); len(links) != 1 || links[0] != "123/index.html" {
+		t.Errorf("links %q, want the user's page", links)
+	}
+	if !strings.Contains(out, "@some&lt;one&gt;</a> <span class=\"muted\">4 media</span>") {
+		t.Errorf("user entry missing in\n%s", out)
+	}
+}
@@ -0,0 +1,114 @@
+package gallery
+
+import "html/template"
+
+// style is shared by the user pages and the index page
+const style = `
+body { font-family: system-ui, sans-serif; margin: 0; background: #f5f8fa; color: #14171a; }
+header { padding: 16px 24px; background: #fff; border-bottom: 1px solid #e1e8ed; position: sticky; top: 0; z-index: 1; }
+header h1 { margin: 0 0 8px; font-size: 20px; }
+header .muted, .muted { color: #657786; font-size: 13px; }
+form { display: flex; flex-wrap: wrap; gap: 12px; align-items: center; font-size: 14px; }
+main { display: grid; grid-template-columns: repeat(auto-fill, minmax(220px, 1fr)); gap: 16px; padding: 24px; }
+.card { background: #fff; border: 1px solid #e1e8ed; border-radius: 8px; overflow: hidden; display: flex; flex-direction: column; }
+.card .media { background: #000; aspect-ratio: 1; display: flex; align-items: center; justify-content: center; }
+.card .media img, .card .media video { width: 100%; height: 100%; object-fit: cover; }
+.card .media span { color: #aab8c2; font-size: 13px; padding: 8px; text-align: center; word-break: break-all; }
+.card .meta { padding: 8px 10px; font-size: 13px; }
+.card .text { white-space: pre-wrap; overflow-wrap: anywhere; max-height: 7.5em; overflow: hidden; margin: 4px 0; }
+ul.users { list-style: none; padding: 24px; margin: 0; }
+ul.users li { padding: 8px 0; }
+a { color: #1b95e0; text-decoration: none; }
+`
+
+var pageTemplate = template.Must(template.New("page").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{if .UserName}}@{{.UserName}}{{else}}{{.UserId}}{{end}}</title>
+<style>` + style + `</style>
+</head>
+<body>
+<header>
+  <h1>{{if .DisplayName}}{{.DisplayName}} {{end}}{{if .UserName}}<span class="muted">@{{.UserName}}</span>{{end}}</h1>
+  <form id="filters">
+    <label>From <input type="date" name="from"></label>
+    <label>To <input type="date" name="to"></label>
+    <label><input type="checkbox" name="type" value="photo" checked> Photos</label>
+    <label><input type="checkbox" name="type" value="video" checked> Videos</label>
+    <label><input type="checkbox" name="type" value="animated_gif" checked> GIFs</label>
+    <span class="muted"><span id="shown">{{len .Items}}</span> of {{len .Items}} shown, generated {{.Generated.Format "2006-01-02 15:04"}}</span>
+  </form>
+</header>
+<main>
+{{- range .Items}}
+  <div class="card" data-date="{{.Date}}" data-type="{{.Type}}">
+    <div class="media">
+    {{- if and .Href (eq .Type "photo")}}
+      <a href="{{.Href}}"><img src="{{.Thumb}}" loading="lazy" alt=""></a>
+    {{- else if .Href}}
+      <video src="{{.Href}}" {{if .Thumb}}poster="{{.Thumb}}" {{end}}controls preload="none"{{if eq .Type "animated_gif"}} loop muted{{end}}></video>
+    {{- else if .Location}}
+      <span>{{.Location}}</span>
+    {{- else}}
+      <span>not in this folder</span>
+    {{- end}}
+    </div>
+    <div class="meta">
+      <div class="muted">{{.Date}} · {{.Type}}{{if eq .Source "likes"}} · liked{{end}}</div>
+      {{- if .Text}}
+      <div class="text">{{.Text}}</div>
+      {{- end}}
+      {{- if .TweetURL}}
+      <a href="{{.TweetURL}}" target="_blank" rel="noopener">View tweet</a>
+      {{- end}}
+    </div>
+  </div>
+{{- end}}
+</main>
+<script>
+(function () {
+  var form = document.getElementById('filters');
+  var cards = document.querySelectorAll('.card');
+  function apply() {
+    var from = form.from.value, to = form.to.value, types = {};
+    form.querySelectorAll('input[name=type]').forEach(function (box) { types[box.value] = box.checked; });
+    var shown = 0;
+    cards.forEach(function (card) {
+      var date = card.dataset.date, type = card.dataset.type;
+      var visible = (!from || date >= from) && (!to || date <= to) && types[type] !== false;
+      card.style.display = visible ? '' : 'none';
+      if (visible) shown++;
+    });
+    document.getElementById('shown').textContent = shown;
+  }
+  form.addEventListener('input', apply);
+  form.addEventListener('submit', function (e) { e.preventDefault(); });
+})();
+</script>
+</body>
+</html>
+`))
+
+var indexTemplate = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Archive</title>
+<style>` + style + `</style>
+</head>
+<body>
+<header>
+  <h1>Archive</h1>
+  <div class="muted">{{len .Users}} users, generated {{.Generated.Format "2006-01-02 15:04"}}</div>
+</header>
+<ul class="users">
+{{- range .Users}}
+  <li><a href="{{.Href}}">{{if .DisplayName}}{{.DisplayName}} {{end}}{{if .UserName}}@{{.UserName}}{{else}}{{.UserId}}{{end}}</a> <span class="muted">{{.Count}} media</span></li>
+{{- end}}
+</ul>
+</body>
+</html>
+`))
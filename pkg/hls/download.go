@@ -0,0 +1,342 @@
+package hls
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"runtime"
+	"sort"
+)
+
+// ErrEncrypted is returned for playlists using EXT-X-KEY encryption
+var ErrEncrypted = errors.New("hls: encrypted playlists are not supported")
+
+// ErrUnsupportedMux is returned when separate audio and video renditions can
+// only be combined by re-encoding or a full MPEG-TS remux
+var ErrUnsupportedMux = errors.New("hls: cannot combine separate MPEG-TS renditions")
+
+const maxRetry = 3
+
+// Downloader fetches HLS streams and writes them out as a single file
+type Downloader struct {
+	// Client is used for every playlist and segment request,
+	// http.DefaultClient when nil
+	Client *http.Client
+	// Concurrency is the number of segments fetched in parallel,
+	// runtime.NumCPU() when zero
+	Concurrency int
+	// Header is added to every request
+	Header http.Header
+	// Progress, when set, is called after each segment is written with the
+	// bytes written so far and the number of segments done out of total
+	Progress func(bytes int64, done int, total int)
+}
+
+// Result describes a finished download
+type Result struct {
+	// Ext is the file extension matching the written container,
+	// one of .mp4, .m4a, .ts or .aac
+	Ext      string
+	Segments int
+	Bytes    int64
+	Duration float64
+}
+
+// track is one media playlist taking part in the output
+type track struct {
+	playlist *Playlist
+	init     []byte
+}
+
+// job is a segment scheduled for download, tagged with its track
+type job struct {
+	track int
+	start float64
+	seg   Segment
+}
+
+// Download resolves playlistURL (master or media playlist), picks the highest
+// bandwidth variant plus its audio rendition, fetches every segment and writes
+// the combined stream to w.
+func (d *Downloader) Download(ctx context.Context, playlistURL string, w io.Writer) (*Result, error) {
+	root, err := d.fetchPlaylist(ctx, playlistURL)
+	if err != nil {
+		return nil, err
+	}
+
+	var mediaURLs []string
+	if root.Master {
+		if variant, ok := root.BestVariant(); ok {
+			mediaURLs = append(mediaURLs, variant.URI)
+			if audio, ok := root.AudioRendition(variant.Audio); ok && variant.Audio != "" {
+				mediaURLs = append(mediaURLs, audio.URI)
+			}
+		} else if audio, ok := root.AudioRendition(""); ok {
+			mediaURLs = append(mediaURLs, audio.URI)
+		}
+		if len(mediaURLs) == 0 {
+			return nil, fmt.Errorf("hls: master playlist %s has no playable variant", playlistURL)
+		}
+	}
+
+	var tracks []track
+	if root.Master {
+		for _, u := range mediaURLs {
+			pl, err := d.fetchPlaylist(ctx, u)
+			if err != nil {
+				return nil, err
+			}
+			tracks = append(tracks, track{playlist: pl})
+		}
+	} else {
+		tracks = append(tracks, track{playlist: root})
+	}
+
+	for i := range tracks {
+		pl := tracks[i].playlist
+		if pl.Encrypted {
+			return nil, ErrEncrypted
+		}
+		if len(pl.Segments) == 0 {
+			return nil, fmt.Errorf("hls: media playlist has no segments")
+		}
+		if pl.Init != nil {
+			tracks[i].init, err = d.fetch(ctx, pl.Init.URI, pl.Init.ByteRange)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	cw := &countingWriter{w: w}
+	result := &Result{Duration: tracks[0].playlist.Duration()}
+
+	if tracks[0].init != nil {
+		err = d.writeFMP4(ctx, tracks, cw, result)
+	} else {
+		if len(tracks) > 1 {
+			return nil, ErrUnsupportedMux
+		}
+		err = d.writeConcat(ctx, tracks[0].playlist.Segments, cw, result)
+	}
+	if err != nil {
+		return nil, err
+	}
+	result.Bytes = cw.n
+	return result, nil
+}
+
+// writeFMP4 merges the init sections and interleaves the fragments of every
+// track by segment start time
+func (d *Downloader) writeFMP4(ctx context.Context, tracks []track, w *countingWriter, result *Result) error {
+	muxer := &fmp4Muxer{w: w}
+	inits := make([][]byte, len(tracks))
+	for i, t := range tracks {
+		if t.init == nil {
+			return ErrUnsupportedMux
+		}
+		inits[i] = t.init
+	}
+	if err := muxer.writeInit(inits); err != nil {
+		return err
+	}
+
+	var jobs []job
+	for i, t := range tracks {
+		var start float64
+		for _, seg := range t.playlist.Segments {
+			jobs = append(jobs, job{track: i, start: start, seg: seg})
+			start += seg.Duration
+		}
+	}
+	sort.SliceStable(jobs, func(a, b int) bool { return jobs[a].start < jobs[b].start })
+
+	segments := make([]Segment, len(jobs))
+	for i, j := range jobs {
+		segments[i] = j.seg
+	}
+	err := d.fetchOrdered(ctx, segments, func(i int, data []byte) error {
+		if err := muxer.writeFragment(uint32(jobs[i].track+1), data); err != nil {
+			return err
+		}
+		d.progress(w.n, i+1, len(segments))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	result.Segments = len(jobs)
+	result.Ext = ".m4a"
+	if muxer.hasVideo {
+		result.Ext = ".mp4"
+	}
+	return nil
+}
+
+// writeConcat appends MPEG-TS or raw AAC segments to w in playlist order,
+// which yields a playable stream for both formats
+func (d *Downloader) writeConcat(ctx context.Context, segments []Segment, w *countingWriter, result *Result) error {
+	err := d.fetchOrdered(ctx, segments, func(i int, data []byte) error {
+		if i == 0 {
+			result.Ext = sniffContainer(data)
+		}
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+		d.progress(w.n, i+1, len(segments))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	result.Segments = len(segments)
+	return nil
+}
+
+type fetchResult struct {
+	data []byte
+	err  error
+}
+
+// fetchOrdered downloads segments concurrently and hands them to handle in
+// order. At most Concurrency segments are in flight or waiting to be handled,
+// which bounds memory use for long streams.
+func (d *Downloader) fetchOrdered(ctx context.Context, segments []Segment, handle func(i int, data []byte) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make([]chan fetchResult, len(segments))
+	for i := range results {
+		results[i] = make(chan fetchResult, 1)
+	}
+	slots := make(chan struct{}, d.concurrency())
+
+	go func() {
+		for i, seg := range segments {
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+				results[i] <- fetchResult{err: ctx.Err()}
+				return
+			}
+			go func(i int, seg Segment) {
+				data, err := d.fetch(ctx, seg.URI, seg.ByteRange)
+				results[i] <- fetchResult{data: data, err: err}
+			}(i, seg)
+		}
+	}()
+
+	for i := range segments {
+		r := <-results[i]
+		// a segment given up on cancellation never took a slot
+		if r.err != nil {
+			return r.err
+		}
+		<-slots
+		if err := handle(i, r.data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *Downloader) fetchPlaylist(ctx context.Context, rawURL string) (*Playlist, error) {
+	base, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	data, err := d.fetch(ctx, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(bytes.NewReader(data), base)
+}
+
+// fetch GETs rawURL, retrying up to maxRetry times like the media downloader
+func (d *Downloader) fetch(ctx context.Context, rawURL string, br *ByteRange) ([]byte, error) {
+	var lastErr error
+	for attempt := 0; attempt <= maxRetry; attempt++ {
+		data, err := d.fetchOnce(ctx, rawURL, br)
+		if err == nil {
+			return data, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+func (d *Downloader) fetchOnce(ctx context.Context, rawURL string, br *ByteRange) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range d.Header {
+		req.Header[k] = v
+	}
+	if br != nil {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", br.Offset, br.Offset+br.Length-1))
+	}
+
+	resp, err := d.client().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		return nil, fmt.Errorf("hls: GET %s: %s", rawURL, resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+func (d *Downloader) progress(bytes int64, done int, total int) {
+	if d.Progress != nil {
+		d.Progress(bytes, done, total)
+	}
+}
+
+func (d *Downloader) client() *http.Client {
+	if d.Client != nil {
+		return d.Client
+	}
+	return http.DefaultClient
+}
+
+func (d *Downloader) concurrency() int {
+	if d.Concurrency > 0 {
+		return d.Concurrency
+	}
+	return runtime.NumCPU()
+}
+
+// sniffContainer guesses the extension of a segment without an init section
+func sniffContainer(data []byte) string {
+	switch {
+	case len(data) > 0 && data[0] == 0x47:
+		return ".ts"
+	case len(data) >= 8 && (string(data[4:8]) == "ftyp" || string(data[4:8]) == "styp" || string(data[4:8]) == "moof"):
+		return ".mp4"
+	default:
+		// ID3 tagged or bare ADTS audio, as used by Spaces
+		return ".aac"
+	}
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,307 @@
+package hls
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// segmentServer serves /seg/<n> as "segment <n>", later segments sooner,
+// and records the most requests it had in flight
+func segmentServer(t *testing.T) (*httptest.Server, *atomic.Int32) {
+	var inFlight, most atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			m := most.Load()
+			if n <= m || most.CompareAndSwap(m, n) {
+				break
+			}
+		}
+		i, _ := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/seg/"))
+		select {
+		case <-time.After(time.Duration(10-i%10) * time.Millisecond):
+		case <-r.Context().Done():
+			return
+		}
+		fmt.Fprintf(w, "segment %d", i)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &most
+}
+
+func segments(base string, n int) []Segment {
+	var out []Segment
+	for i := 0; i < n; i++ {
+		out = append(out, Segment{URI: fmt.Sprintf("%s/seg/%d", base, i), Duration: 1})
+	}
+	return out
+}
+
+func TestFetchOrdered(t *testing.T) {
+	srv, most := segmentServer(t)
+	d := &Downloader{Client: srv.Client(), Concurrency: 4}
+
+	var handled []string
+	err := d.fetchOrdered(context.Background(), segments(srv.URL, 20), func(i int, data []byte) error {
+		if want := fmt.Sprintf("segment %d", i); string(data) != want {
+			t.Errorf("segment %d handled with %q", i, data)
+		}
+		handled = append(handled, string(data))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(handled) != 20 {
+		t.Errorf("%d segments handled, want 20", len(handled))
+	}
+	if m := most.Load(); m > 4 {
+		t.Errorf("%d requests in flight, want at most 4", m)
+	}
+}
+
+func TestFetchOrderedStopsOnError(t *testing.T) {
+	srv, _ := segmentServer(t)
+	d := &Downloader{Client: srv.Client(), Concurrency: 3}
+
+	stop := errors.New("disk full")
+	var calls int
+	err := d.fetchOrdered(context.Background(), segments(srv.URL, 50), func(i int, data []byte) error {
+		calls++
+		if i == 2 {
+			return stop
+		}
+		return nil
+	})
+	if err != stop {
+		t.Errorf("got %v, want the handler's error", err)
+	}
+	if calls != 3 {
+		t.Errorf("handler called %d times, want 3", calls)
+	}
+}
+
+func TestFetchOrderedCancelled(t *testing.T) {
+	srv, _ := segmentServer(t)
+	d := &Downloader{Client: srv.Client(), Concurrency: 2}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var calls int
+	err := d.fetchOrdered(ctx, segments(srv.URL, 50), func(i int, data []byte) error {
+		calls++
+		if i == 4 {
+			cancel()
+		}
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, want %v", err, context.Canceled)
+	}
+	if calls > 7 {
+		t.Errorf("handler called %d times after cancelling at the fifth segment", calls)
+	}
+}
+
+func TestFetchOrderedReturnsWhenCancelled(t *testing.T) {
+	srv, _ := segmentServer(t)
+	d := &Downloader{Client: srv.Client(), Concurrency: 2}
+
+	// the handler cancels once the next segments have arrived, so the
+	// producer gives up on a segment while every slot is still held
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- d.fetchOrdered(ctx, segments(srv.URL, 10), func(i int, data []byte) error {
+			if i == 2 {
+				time.Sleep(100 * time.Millisecond)
+				cancel()
+			}
+			return nil
+		})
+	}()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("got %v, want %v", err, context.Canceled)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("fetchOrdered still running 5s after being cancelled")
+	}
+}
+
+func TestFetchRetries(t *testing.T) {
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := requests.Add(1)
+		if r.URL.Path == "/flaky" && n > 2 {
+			w.Write([]byte("ok"))
+			return
+		}
+		http.Error(w, "busy", http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+	d := &Downloader{Client: srv.Client()}
+
+	data, err := d.fetch(context.Background(), srv.URL+"/flaky", nil)
+	if err != nil || string(data) != "ok" {
+		t.Errorf("flaky segment: got %q, %v", data, err)
+	}
+	if n := requests.Load(); n != 3 {
+		t.Errorf("flaky segment requested %d times, want 3", n)
+	}
+
+	requests.Store(0)
+	if _, err := d.fetch(context.Background(), srv.URL+"/down", nil); err == nil || !strings.Contains(err.Error(), "503") {
+		t.Errorf("failing segment: got %v, want the last status", err)
+	}
+	if n := requests.Load(); n != maxRetry+1 {
+		t.Errorf("failing segment requested %d times, want %d", n, maxRetry+1)
+	}
+}
+
+// streamServer serves the files of a stream, honouring Range requests
+func streamServer(t *testing.T, files map[string][]byte) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, ok := files[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		http.ServeContent(w, r, r.URL.Path, time.Time{}, bytes.NewReader(data))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestDownloadFMP4(t *testing.T) {
+	video := append(testFragment(1, 1, "v0"), testFragment(2, 1, "v1")...)
+	files := map[string][]byte{
+		"/master.m3u8": []byte(`#EXTM3U
+#EXT-X-MEDIA:NAME="Audio",TYPE=AUDIO,GROUP-ID="aud",URI="audio.m3u8"
+#EXT-X-STREAM-INF:BANDWIDTH=100000,AUDIO="aud"
+low.m3u8
+#EXT-X-STREAM-INF:BANDWIDTH=900000,AUDIO="aud"
+high.m3u8
+`),
+		// the video uses byte ranges of one file
+		"/high.m3u8": []byte(fmt.Sprintf(`#EXTM3U
+#EXT-X-MAP:URI="video.mp4",BYTERANGE="%d@0"
+#EXTINF:2,
+#EXT-X-BYTERANGE:%d@%d
+video.mp4
+#EXTINF:2,
+#EXT-X-BYTERANGE:%d
+video.mp4
+#EXT-X-ENDLIST
+`, len(testInit("vide", 1)), len(video)/2, len(testInit("vide", 1)), len(video)/2)),
+		"/video.mp4": append(testInit("vide", 1), video...),
+		"/audio.m3u8": []byte(`#EXTM3U
+#EXT-X-MAP:URI="audio/init.mp4"
+#EXTINF:2,
+audio/0.m4s
+#EXTINF:2,
+audio/1.m4s
+#EXT-X-ENDLIST
+`),
+		"/audio/init.mp4": testInit("soun", 1),
+		"/audio/0.m4s":    testFragment(1, 1, "a0"),
+		"/audio/1.m4s":    testFragment(2, 1, "a1"),
+	}
+	srv := streamServer(t, files)
+
+	var progress []int
+	d := &Downloader{Client: srv.Client(), Concurrency: 2, Progress: func(bytes int64, done int, total int) {
+		progress = append(progress, done)
+	}}
+	var out bytes.Buffer
+	result, err := d.Download(context.Background(), srv.URL+"/master.m3u8", &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Ext != ".mp4" || result.Segments != 4 || result.Duration != 4 || result.Bytes != int64(out.Len()) {
+		t.Errorf("result %+v", *result)
+	}
+	if len(progress) != 4 || progress[3] != 4 {
+		t.Errorf("progress reported %v", progress)
+	}
+
+	top := mustBoxes(t, out.Bytes())
+	var data []string
+	var tracks []uint32
+	for _, b := range top {
+		switch b.typ {
+		case "mdat":
+			data = append(data, string(b.payload))
+		case "moof":
+			tfhd, _ := findPath(b.payload, "traf", "tfhd")
+			tracks = append(tracks, binary.BigEndian.Uint32(tfhd.payload[4:]))
+		}
+	}
+	// segments of both tracks interleave by start time
+	if got := strings.Join(data, ","); got != "v0,a0,v1,a1" {
+		t.Errorf("fragments %s, want v0,a0,v1,a1", got)
+	}
+	if fmt.Sprint(tracks) != "[1 2 1 2]" {
+		t.Errorf("fragment tracks %v, want [1 2 1 2]", tracks)
+	}
+}
+
+func TestDownloadConcat(t *testing.T) {
+	ts := func(s string) []byte { return append([]byte{0x47}, s...) }
+	srv := streamServer(t, map[string][]byte{
+		"/space.m3u8": []byte("#EXTM3U\n#EXTINF:3,\n0.ts\n#EXTINF:3,\n1.ts\n#EXTINF:1.5,\n2.ts\n"),
+		"/0.ts":       ts("zero"),
+		"/1.ts":       ts("one"),
+		"/2.ts":       ts("two"),
+	})
+	d := &Downloader{Client: srv.Client()}
+	var out bytes.Buffer
+	result, err := d.Download(context.Background(), srv.URL+"/space.m3u8", &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "\x47zero\x47one\x47two"; out.String() != want {
+		t.Errorf("wrote %q, want %q", out.String(), want)
+	}
+	if result.Ext != ".ts" || result.Segments != 3 || result.Duration != 7.5 {
+		t.Errorf("result %+v", *result)
+	}
+}
+
+func TestDownloadUnsupported(t *testing.T) {
+	srv := streamServer(t, map[string][]byte{
+		"/encrypted.m3u8": []byte("#EXTM3U\n#EXT-X-KEY:METHOD=AES-128,URI=\"key\"\n#EXTINF:3,\n0.ts\n"),
+		"/master.m3u8":    []byte("#EXTM3U\n#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID=\"a\",URI=\"audio.m3u8\"\n#EXT-X-STREAM-INF:BANDWIDTH=1,AUDIO=\"a\"\nvideo.m3u8\n"),
+		"/video.m3u8":     []byte("#EXTM3U\n#EXTINF:3,\n0.ts\n"),
+		"/audio.m3u8":     []byte("#EXTM3U\n#EXTINF:3,\n0.aac\n"),
+		"/empty.m3u8":     []byte("#EXTM3U\n#EXT-X-ENDLIST\n"),
+	})
+	d := &Downloader{Client: srv.Client()}
+	tests := []struct {
+		playlist string
+		want     error
+	}{
+		{"encrypted.m3u8", ErrEncrypted},
+		{"master.m3u8", ErrUnsupportedMux},
+		{"empty.m3u8", nil},
+		{"missing.m3u8", nil},
+	}
+	for _, tt := range tests {
+		_, err := d.Download(context.Background(), srv.URL+"/"+tt.playlist, &bytes.Buffer{})
+		if err == nil || (tt.want != nil && !errors.Is(err, tt.want)) {
+			t.Errorf("%s: got %v, want %v", tt.playlist, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,250 @@
+package hls
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// box is an ISO BMFF box. raw covers the whole box including its header and
+// payload aliases the bytes after the header, so edits made through payload
+// are visible in raw.
+type box struct {
+	typ     string
+	raw     []byte
+	payload []byte
+}
+
+var errShortBox = errors.New("hls: truncated mp4 box")
+
+// parseBoxes splits b into its top-level boxes
+func parseBoxes(b []byte) ([]box, error) {
+	var boxes []box
+	for len(b) > 0 {
+		if len(b) < 8 {
+			return nil, errShortBox
+		}
+		size := uint64(binary.BigEndian.Uint32(b[0:4]))
+		typ := string(b[4:8])
+		hdr := uint64(8)
+		switch size {
+		case 0:
+			size = uint64(len(b))
+		case 1:
+			if len(b) < 16 {
+				return nil, errShortBox
+			}
+			size = binary.BigEndian.Uint64(b[8:16])
+			hdr = 16
+		}
+		if size < hdr || size > uint64(len(b)) {
+			return nil, errShortBox
+		}
+		boxes = append(boxes, box{typ: typ, raw: b[:size], payload: b[hdr:size]})
+		b = b[size:]
+	}
+	return boxes, nil
+}
+
+// findBox returns the first box of the given type
+func findBox(boxes []box, typ string) (box, bool) {
+	for _, b := range boxes {
+		if b.typ == typ {
+			return b, true
+		}
+	}
+	return box{}, false
+}
+
+// findPath walks nested container boxes, e.g. findPath(b, "mdia", "hdlr")
+func findPath(payload []byte, path ...string) (box, bool) {
+	var cur box
+	for _, typ := range path {
+		children, err := parseBoxes(payload)
+		if err != nil {
+			return box{}, false
+		}
+		var ok bool
+		if cur, ok = findBox(children, typ); !ok {
+			return box{}, false
+		}
+		payload = cur.payload
+	}
+	return cur, true
+}
+
+func appendBox(dst []byte, typ string, payloads ...[]byte) []byte {
+	size := 8
+	for _, p := range payloads {
+		size += len(p)
+	}
+	dst = binary.BigEndian.AppendUint32(dst, uint32(size))
+	dst = append(dst, typ...)
+	for _, p := range payloads {
+		dst = append(dst, p...)
+	}
+	return dst
+}
+
+// setTkhdTrackID rewrites the track_ID of a tkhd box in place
+func setTkhdTrackID(tkhd box, id uint32) error {
+	offset := 12
+	if len(tkhd.payload) > 0 && tkhd.payload[0] == 1 {
+		offset = 20
+	}
+	if len(tkhd.payload) < offset+4 {
+		return errShortBox
+	}
+	binary.BigEndian.PutUint32(tkhd.payload[offset:], id)
+	return nil
+}
+
+// setFullBoxUint32 writes v right after the version/flags header of a full
+// box, which is where trex, tfhd and mfhd keep their first field
+func setFullBoxUint32(b box, v uint32) error {
+	if len(b.payload) < 8 {
+		return errShortBox
+	}
+	binary.BigEndian.PutUint32(b.payload[4:8], v)
+	return nil
+}
+
+// fmp4Muxer combines the fragmented MP4 streams of separate HLS renditions
+// (typically one video and one audio playlist) into a single fragmented MP4
+// with one track per input. Fragments are copied as-is apart from their track
+// and sequence numbers, so no sample data is rewritten.
+type fmp4Muxer struct {
+	w        io.Writer
+	sequence uint32
+	hasVideo bool
+}
+
+// writeInit merges the init sections of every input, assigning track IDs
+// 1..n in input order
+func (m *fmp4Muxer) writeInit(inits [][]byte) error {
+	var (
+		ftyp  []byte
+		mvhd  []byte
+		traks [][]byte
+		trexs [][]byte
+	)
+	for i, init := range inits {
+		trackID := uint32(i + 1)
+		top, err := parseBoxes(init)
+		if err != nil {
+			return err
+		}
+		if b, ok := findBox(top, "ftyp"); ok && ftyp == nil {
+			ftyp = b.raw
+		}
+		moov, ok := findBox(top, "moov")
+		if !ok {
+			return fmt.Errorf("hls: init section %d has no moov box", i)
+		}
+		children, err := parseBoxes(moov.payload)
+		if err != nil {
+			return err
+		}
+		var trakCount int
+		for _, child := range children {
+			switch child.typ {
+			case "mvhd":
+				if mvhd == nil {
+					mvhd = append([]byte(nil), child.raw...)
+				}
+			case "trak":
+				trakCount++
+				trak := append([]byte(nil), child.raw...)
+				parsed, _ := parseBoxes(trak)
+				tkhd, ok := findPath(parsed[0].payload, "tkhd")
+				if !ok {
+					return fmt.Errorf("hls: init section %d has no tkhd box", i)
+				}
+				if err := setTkhdTrackID(tkhd, trackID); err != nil {
+					return err
+				}
+				if hdlr, ok := findPath(parsed[0].payload, "mdia", "hdlr"); ok && len(hdlr.payload) >= 12 &&
+					string(hdlr.payload[8:12]) == "vide" {
+					m.hasVideo = true
+				}
+				traks = append(traks, trak)
+			case "mvex":
+				trex, ok := findPath(child.payload, "trex")
+				if !ok {
+					continue
+				}
+				raw := append([]byte(nil), trex.raw...)
+				parsed, _ := parseBoxes(raw)
+				if err := setFullBoxUint32(parsed[0], trackID); err != nil {
+					return err
+				}
+				trexs = append(trexs, raw)
+			}
+		}
+		if trakCount != 1 {
+			return fmt.Errorf("hls: init section %d has %d tracks, want 1", i, trakCount)
+		}
+	}
+	if mvhd == nil {
+		return errors.New("hls: init section has no mvhd box")
+	}
+
+	// next_track_ID is the last field of mvhd
+	binary.BigEndian.PutUint32(mvhd[len(mvhd)-4:], uint32(len(inits)+1))
+
+	var moovPayload []byte
+	moovPayload = append(moovPayload, mvhd...)
+	for _, t := range traks {
+		moovPayload = append(moovPayload, t...)
+	}
+	moovPayload = appendBox(moovPayload, "mvex", trexs...)
+
+	out := append([]byte(nil), ftyp...)
+	out = appendBox(out, "moov", moovPayload)
+	_, err := m.w.Write(out)
+	return err
+}
+
+// writeFragment copies the moof/mdat pairs of a media segment belonging to
+// the input with the given track ID. styp, sidx and other boxes are dropped
+// because their offsets no longer hold in the merged file.
+func (m *fmp4Muxer) writeFragment(trackID uint32, segment []byte) error {
+	top, err := parseBoxes(segment)
+	if err != nil {
+		return err
+	}
+	for _, b := range top {
+		switch b.typ {
+		case "moof":
+			children, err := parseBoxes(b.payload)
+			if err != nil {
+				return err
+			}
+			for _, child := range children {
+				switch child.typ {
+				case "mfhd":
+					m.sequence++
+					if err := setFullBoxUint32(child, m.sequence); err != nil {
+						return err
+					}
+				case "traf":
+					tfhd, ok := findPath(child.payload, "tfhd")
+					if !ok {
+						return errors.New("hls: traf without tfhd")
+					}
+					if err := setFullBoxUint32(tfhd, trackID); err != nil {
+						return err
+					}
+				}
+			}
+		case "mdat":
+		default:
+			continue
+		}
+		if _, err := m.w.Write(b.raw); err != nil {
+			return err
+		}
+	}
+	return nil
+}
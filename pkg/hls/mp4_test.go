@@ -0,0 +1,152 @@
+package hls
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// fullBox is the payload of a full box: version 0, no flags, then fields
+func fullBox(fields ...uint32) []byte {
+	b := make([]byte, 4)
+	for _, f := range fields {
+		b = binary.BigEndian.AppendUint32(b, f)
+	}
+	return b
+}
+
+// testInit builds the init section of a single track stream with the
+// given handler, "vide" or "soun", and track ID
+func testInit(handler string, trackID uint32) []byte {
+	mvhd := make([]byte, 100)
+	binary.BigEndian.PutUint32(mvhd[96:], trackID+1)
+	tkhd := fullBox(0, 0, trackID, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0)
+	hdlr := append(fullBox(0), handler...)
+	hdlr = append(hdlr, make([]byte, 13)...)
+	trak := appendBox(nil, "tkhd", tkhd)
+	trak = appendBox(trak, "mdia", appendBox(nil, "hdlr", hdlr))
+	moov := appendBox(nil, "mvhd", mvhd)
+	moov = appendBox(moov, "trak", trak)
+	moov = appendBox(moov, "mvex", appendBox(nil, "trex", fullBox(trackID, 1, 0, 0, 0)))
+
+	out := appendBox(nil, "ftyp", []byte("iso6\x00\x00\x00\x00iso6"))
+	return appendBox(out, "moov", moov)
+}
+
+// testFragment builds a media segment with one moof/mdat pair
+func testFragment(sequence, trackID uint32, data string) []byte {
+	traf := appendBox(nil, "tfhd", fullBox(trackID))
+	traf = appendBox(traf, "trun", fullBox(0))
+	moof := appendBox(nil, "mfhd", fullBox(sequence))
+	moof = appendBox(moof, "traf", traf)
+	out := appendBox(nil, "styp", []byte("msdh\x00\x00\x00\x00"))
+	out = appendBox(out, "sidx", fullBox(trackID, 0, 0))
+	out = appendBox(out, "moof", moof)
+	return appendBox(out, "mdat", []byte(data))
+}
+
+func mustBoxes(t *testing.T, b []byte) []box {
+	t.Helper()
+	boxes, err := parseBoxes(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return boxes
+}
+
+func types(boxes []box) []string {
+	var out []string
+	for _, b := range boxes {
+		out = append(out, b.typ)
+	}
+	return out
+}
+
+func TestMuxerWriteInit(t *testing.T) {
+	var buf bytes.Buffer
+	m := &fmp4Muxer{w: &buf}
+	// both inputs number their only track 1
+	if err := m.writeInit([][]byte{testInit("vide", 1), testInit("soun", 1)}); err != nil {
+		t.Fatal(err)
+	}
+	if !m.hasVideo {
+		t.Error("video track not noticed")
+	}
+
+	top := mustBoxes(t, buf.Bytes())
+	if got := types(top); len(got) != 2 || got[0] != "ftyp" || got[1] != "moov" {
+		t.Fatalf("top level boxes %v, want ftyp and moov", got)
+	}
+	moov := mustBoxes(t, top[1].payload)
+	if got := types(moov); len(got) != 4 || got[0] != "mvhd" || got[1] != "trak" || got[2] != "trak" || got[3] != "mvex" {
+		t.Fatalf("moov holds %v, want mvhd, two trak and mvex", got)
+	}
+	if next := binary.BigEndian.Uint32(moov[0].payload[96:]); next != 3 {
+		t.Errorf("next_track_ID %d, want 3", next)
+	}
+	for i, trak := range moov[1:3] {
+		tkhd, _ := findPath(trak.payload, "tkhd")
+		if id := binary.BigEndian.Uint32(tkhd.payload[12:]); id != uint32(i+1) {
+			t.Errorf("track %d has track_ID %d", i+1, id)
+		}
+	}
+	trexs := mustBoxes(t, moov[3].payload)
+	if len(trexs) != 2 {
+		t.Fatalf("%d trex boxes, want 2", len(trexs))
+	}
+	for i, trex := range trexs {
+		if id := binary.BigEndian.Uint32(trex.payload[4:]); id != uint32(i+1) {
+			t.Errorf("trex %d has track_ID %d", i+1, id)
+		}
+	}
+}
+
+func TestMuxerWriteInitErrors(t *testing.T) {
+	twoTracks := testInit("vide", 1)
+	top, _ := parseBoxes(twoTracks)
+	moov, _ := parseBoxes(top[1].payload)
+	payload := append(append(append([]byte(nil), moov[0].raw...), moov[1].raw...), moov[1].raw...)
+	twoTracks = appendBox(append([]byte(nil), top[0].raw...), "moov", payload)
+
+	tests := map[string][]byte{
+		"no moov":    appendBox(nil, "ftyp", []byte("iso6")),
+		"two tracks": twoTracks,
+		"truncated":  testInit("vide", 1)[:40],
+	}
+	for name, init := range tests {
+		m := &fmp4Muxer{w: &bytes.Buffer{}}
+		if err := m.writeInit([][]byte{init}); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+}
+
+func TestMuxerWriteFragment(t *testing.T) {
+	var buf bytes.Buffer
+	m := &fmp4Muxer{w: &buf}
+	if err := m.writeFragment(1, testFragment(7, 1, "video")); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.writeFragment(2, testFragment(7, 1, "audio")); err != nil {
+		t.Fatal(err)
+	}
+
+	top := mustBoxes(t, buf.Bytes())
+	if got := types(top); len(got) != 4 || got[0] != "moof" || got[1] != "mdat" || got[2] != "moof" || got[3] != "mdat" {
+		t.Fatalf("boxes %v, want moof and mdat twice without styp or sidx", got)
+	}
+	for i, want := range []string{"video", "audio"} {
+		moof, mdat := top[2*i], top[2*i+1]
+		mfhd, _ := findPath(moof.payload, "mfhd")
+		if seq := binary.BigEndian.Uint32(mfhd.payload[4:]); seq != uint32(i+1) {
+			t.Errorf("fragment %d has sequence number %d", i+1, seq)
+		}
+		tfhd, _ := findPath(moof.payload, "traf", "tfhd")
+		if id := binary.BigEndian.Uint32(tfhd.payload[4:]); id != uint32(i+1) {
+			t.Errorf("fragment %d belongs to track %d", i+1, id)
+		}
+		if string(mdat.payload) != want {
+			t.Errorf("fragment %d holds %q, want %q", i+1, mdat.payload, want)
+		}
+	}
+}
@@ -0,0 +1,287 @@
+package hls
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// ErrNotPlaylist is returned when the input does not start with #EXTM3U
+var ErrNotPlaylist = errors.New("hls: not an m3u8 playlist")
+
+// Variant is one EXT-X-STREAM-INF entry of a master playlist
+type Variant struct {
+	URI        string
+	Bandwidth  int
+	Resolution string
+	Codecs     string
+	Audio      string
+}
+
+// Rendition is one EXT-X-MEDIA entry of a master playlist
+type Rendition struct {
+	Type    string
+	GroupID string
+	Name    string
+	URI     string
+	Default bool
+}
+
+// ByteRange is the value of an EXT-X-BYTERANGE or BYTERANGE attribute
+type ByteRange struct {
+	Length int64
+	Offset int64
+}
+
+// Segment is one media segment of a media playlist
+type Segment struct {
+	URI       string
+	Duration  float64
+	ByteRange *ByteRange
+}
+
+// InitSection is the EXT-X-MAP of a media playlist, present for fMP4 streams
+type InitSection struct {
+	URI       string
+	ByteRange *ByteRange
+}
+
+// Playlist holds either a master playlist (Variants/Renditions) or a media
+// playlist (Segments). All URIs are resolved against the playlist URL.
+type Playlist struct {
+	Master         bool
+	Variants       []Variant
+	Renditions     []Rendition
+	TargetDuration float64
+	Init           *InitSection
+	Segments       []Segment
+	Encrypted      bool
+}
+
+// Parse reads an m3u8 playlist and resolves its URIs relative to base
+func Parse(r io.Reader, base *url.URL) (*Playlist, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	var (
+		pl          Playlist
+		started     bool
+		pendingVar  *Variant
+		pendingDur  float64
+		pendingBR   *ByteRange
+		nextOffset  int64
+		haveSegInfo bool
+	)
+
+	resolve := func(ref string) (string, error) {
+		u, err := url.Parse(ref)
+		if err != nil {
+			return "", fmt.Errorf("hls: bad uri %q: %w", ref, err)
+		}
+		if base == nil {
+			return u.String(), nil
+		}
+		return base.ResolveReference(u).String(), nil
+	}
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if !started {
+			if !strings.HasPrefix(line, "#EXTM3U") {
+				return nil, ErrNotPlaylist
+			}
+			started = true
+			continue
+		}
+
+		if !strings.HasPrefix(line, "#") {
+			uri, err := resolve(line)
+			if err != nil {
+				return nil, err
+			}
+			switch {
+			case pendingVar != nil:
+				pendingVar.URI = uri
+				pl.Variants = append(pl.Variants, *pendingVar)
+				pendingVar = nil
+			case haveSegInfo:
+				seg := Segment{URI: uri, Duration: pendingDur, ByteRange: pendingBR}
+				if pendingBR != nil {
+					nextOffset = pendingBR.Offset + pendingBR.Length
+				}
+				pl.Segments = append(pl.Segments, seg)
+				pendingDur, pendingBR, haveSegInfo = 0, nil, false
+			}
+			continue
+		}
+
+		tag, value, _ := strings.Cut(line, ":")
+		switch tag {
+		case "#EXT-X-STREAM-INF":
+			attrs := parseAttributes(value)
+			bandwidth, _ := strconv.Atoi(attrs["BANDWIDTH"])
+			pl.Master = true
+			pendingVar = &Variant{
+				Bandwidth:  bandwidth,
+				Resolution: attrs["RESOLUTION"],
+				Codecs:     attrs["CODECS"],
+				Audio:      attrs["AUDIO"],
+			}
+		case "#EXT-X-MEDIA":
+			attrs := parseAttributes(value)
+			pl.Master = true
+			rendition := Rendition{
+				Type:    attrs["TYPE"],
+				GroupID: attrs["GROUP-ID"],
+				Name:    attrs["NAME"],
+				Default: attrs["DEFAULT"] == "YES",
+			}
+			if attrs["URI"] != "" {
+				uri, err := resolve(attrs["URI"])
+				if err != nil {
+					return nil, err
+				}
+				rendition.URI = uri
+			}
+			pl.Renditions = append(pl.Renditions, rendition)
+		case "#EXT-X-TARGETDURATION":
+			pl.TargetDuration, _ = strconv.ParseFloat(value, 64)
+		case "#EXTINF":
+			durStr, _, _ := strings.Cut(value, ",")
+			dur, err := strconv.ParseFloat(strings.TrimSpace(durStr), 64)
+			if err != nil {
+				return nil, fmt.Errorf("hls: bad EXTINF %q: %w", value, err)
+			}
+			pendingDur = dur
+			haveSegInfo = true
+		case "#EXT-X-BYTERANGE":
+			br, err := parseByteRange(value, nextOffset)
+			if err != nil {
+				return nil, err
+			}
+			pendingBR = br
+		case "#EXT-X-MAP":
+			attrs := parseAttributes(value)
+			uri, err := resolve(attrs["URI"])
+			if err != nil {
+				return nil, err
+			}
+			pl.Init = &InitSection{URI: uri}
+			if attrs["BYTERANGE"] != "" {
+				br, err := parseByteRange(attrs["BYTERANGE"], 0)
+				if err != nil {
+					return nil, err
+				}
+				pl.Init.ByteRange = br
+			}
+		case "#EXT-X-KEY":
+			attrs := parseAttributes(value)
+			if attrs["METHOD"] != "" && attrs["METHOD"] != "NONE" {
+				pl.Encrypted = true
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if !started {
+		return nil, ErrNotPlaylist
+	}
+	return &pl, nil
+}
+
+// BestVariant returns the variant with the highest bandwidth
+func (pl *Playlist) BestVariant() (Variant, bool) {
+	if len(pl.Variants) == 0 {
+		return Variant{}, false
+	}
+	best := pl.Variants[0]
+	for _, v := range pl.Variants[1:] {
+		if v.Bandwidth > best.Bandwidth {
+			best = v
+		}
+	}
+	return best, true
+}
+
+// AudioRendition returns the rendition to play alongside the given audio
+// group, preferring the DEFAULT one. Renditions without a URI are muxed into
+// the variant stream and are ignored.
+func (pl *Playlist) AudioRendition(group string) (Rendition, bool) {
+	var found *Rendition
+	for i, r := range pl.Renditions {
+		if r.Type != "AUDIO" || r.URI == "" || (group != "" && r.GroupID != group) {
+			continue
+		}
+		if r.Default {
+			return r, true
+		}
+		if found == nil {
+			found = &pl.Renditions[i]
+		}
+	}
+	if found == nil {
+		return Rendition{}, false
+	}
+	return *found, true
+}
+
+// Duration is the sum of all segment durations of a media playlist
+func (pl *Playlist) Duration() float64 {
+	var total float64
+	for _, s := range pl.Segments {
+		total += s.Duration
+	}
+	return total
+}
+
+func parseByteRange(value string, defaultOffset int64) (*ByteRange, error) {
+	lengthStr, offsetStr, hasOffset := strings.Cut(value, "@")
+	length, err := strconv.ParseInt(lengthStr, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("hls: bad byte range %q: %w", value, err)
+	}
+	br := &ByteRange{Length: length, Offset: defaultOffset}
+	if hasOffset {
+		br.Offset, err = strconv.ParseInt(offsetStr, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("hls: bad byte range %q: %w", value, err)
+		}
+	}
+	return br, nil
+}
+
+// parseAttributes splits an attribute list such as
+// BANDWIDTH=1280000,CODECS="avc1.4d401f,mp4a.40.2" into its key/value pairs
+func parseAttributes(s string) map[string]string {
+	attrs := make(map[string]string)
+	for len(s) > 0 {
+		key, rest, ok := strings.Cut(s, "=")
+		if !ok {
+			break
+		}
+		key = strings.TrimSpace(key)
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.Index(rest[1:], `"`)
+			if end == -1 {
+				value, rest = rest[1:], ""
+			} else {
+				value, rest = rest[1:end+1], rest[end+2:]
+			}
+			_, rest, _ = strings.Cut(rest, ",")
+		} else {
+			value, rest, _ = strings.Cut(rest, ",")
+		}
+		attrs[key] = value
+		s = rest
+	}
+	return attrs
+}
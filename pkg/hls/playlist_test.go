@@ -0,0 +1,172 @@
+package hls
+
+import (
+	"errors"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	base, _ := url.Parse("https://video.twimg.com/ext_tw_video/1/pu/pl/master.m3u8")
+	tests := []struct {
+		name     string
+		playlist string
+		want     Playlist
+	}{
+		{
+			name: "master",
+			playlist: `#EXTM3U
+#EXT-X-INDEPENDENT-SEGMENTS
+#EXT-X-MEDIA:NAME="Audio",TYPE=AUDIO,GROUP-ID="audio-128000",AUTOSELECT=YES,URI="/ext_tw_video/1/pu/pl/mp4a/128000/audio.m3u8"
+#EXT-X-STREAM-INF:AVERAGE-BANDWIDTH=300000,BANDWIDTH=400000,RESOLUTION=480x270,CODECS="mp4a.40.2,avc1.4d001e",AUDIO="audio-128000"
+/ext_tw_video/1/pu/pl/avc1/480x270/low.m3u8
+#EXT-X-STREAM-INF:AVERAGE-BANDWIDTH=2000000,BANDWIDTH=2500000,RESOLUTION=1280x720,CODECS="mp4a.40.2,avc1.640020",AUDIO="audio-128000"
+avc1/1280x720/high.m3u8
+`,
+			want: Playlist{
+				Master: true,
+				Variants: []Variant{
+					{URI: "https://video.twimg.com/ext_tw_video/1/pu/pl/avc1/480x270/low.m3u8", Bandwidth: 400000, Resolution: "480x270", Codecs: "mp4a.40.2,avc1.4d001e", Audio: "audio-128000"},
+					{URI: "https://video.twimg.com/ext_tw_video/1/pu/pl/avc1/1280x720/high.m3u8", Bandwidth: 2500000, Resolution: "1280x720", Codecs: "mp4a.40.2,avc1.640020", Audio: "audio-128000"},
+				},
+				Renditions: []Rendition{
+					{Type: "AUDIO", GroupID: "audio-128000", Name: "Audio", URI: "https://video.twimg.com/ext_tw_video/1/pu/pl/mp4a/128000/audio.m3u8"},
+				},
+			},
+		},
+		{
+			name: "media",
+			playlist: `#EXTM3U
+#EXT-X-VERSION:6
+#EXT-X-TARGETDURATION:3
+#EXT-X-MAP:URI="/ext_tw_video/1/pu/vid/avc1/0/0/1280x720/init.mp4"
+#EXTINF:3.000,
+/ext_tw_video/1/pu/vid/avc1/0/3000/1280x720/a.m4s
+#EXTINF:1.5,
+b.m4s
+#EXT-X-ENDLIST
+`,
+			want: Playlist{
+				TargetDuration: 3,
+				Init:           &InitSection{URI: "https://video.twimg.com/ext_tw_video/1/pu/vid/avc1/0/0/1280x720/init.mp4"},
+				Segments: []Segment{
+					{URI: "https://video.twimg.com/ext_tw_video/1/pu/vid/avc1/0/3000/1280x720/a.m4s", Duration: 3},
+					{URI: "https://video.twimg.com/ext_tw_video/1/pu/pl/b.m4s", Duration: 1.5},
+				},
+			},
+		},
+		{
+			name: "byte ranges",
+			playlist: `#EXTM3U
+#EXT-X-TARGETDURATION:4
+#EXT-X-MAP:URI="video.mp4",BYTERANGE="720@0"
+#EXTINF:4,
+#EXT-X-BYTERANGE:1000@720
+video.mp4
+#EXTINF:4,
+#EXT-X-BYTERANGE:2000
+video.mp4
+#EXTINF:2,
+#EXT-X-BYTERANGE:500@5000
+video.mp4
+`,
+			want: Playlist{
+				TargetDuration: 4,
+				Init:           &InitSection{URI: "https://video.twimg.com/ext_tw_video/1/pu/pl/video.mp4", ByteRange: &ByteRange{Length: 720}},
+				Segments: []Segment{
+					{URI: "https://video.twimg.com/ext_tw_video/1/pu/pl/video.mp4", Duration: 4, ByteRange: &ByteRange{Length: 1000, Offset: 720}},
+					// without an offset the range follows the previous one
+					{URI: "https://video.twimg.com/ext_tw_video/1/pu/pl/video.mp4", Duration: 4, ByteRange: &ByteRange{Length: 2000, Offset: 1720}},
+					{URI: "https://video.twimg.com/ext_tw_video/1/pu/pl/video.mp4", Duration: 2, ByteRange: &ByteRange{Length: 500, Offset: 5000}},
+				},
+			},
+		},
+		{
+			name: "encrypted",
+			playlist: `#EXTM3U
+#EXT-X-TARGETDURATION:6
+#EXT-X-KEY:METHOD=AES-128,URI="https://example.com/key",IV=0x1234
+#EXTINF:6,
+0.ts
+`,
+			want: Playlist{
+				TargetDuration: 6,
+				Encrypted:      true,
+				Segments:       []Segment{{URI: "https://video.twimg.com/ext_tw_video/1/pu/pl/0.ts", Duration: 6}},
+			},
+		},
+		{
+			name: "key method none",
+			playlist: `#EXTM3U
+#EXT-X-KEY:METHOD=NONE
+#EXTINF:6,
+0.ts
+`,
+			want: Playlist{
+				Segments: []Segment{{URI: "https://video.twimg.com/ext_tw_video/1/pu/pl/0.ts", Duration: 6}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(strings.NewReader(tt.playlist), base)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(*got, tt.want) {
+				t.Errorf("got  %+v\nwant %+v", *got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		playlist string
+		want     error
+	}{
+		{"empty", "", ErrNotPlaylist},
+		{"html", "<html></html>", ErrNotPlaylist},
+		{"bad duration", "#EXTM3U\n#EXTINF:long,\n0.ts\n", nil},
+		{"bad byte range", "#EXTM3U\n#EXTINF:1,\n#EXT-X-BYTERANGE:x@1\n0.ts\n", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(strings.NewReader(tt.playlist), nil)
+			if err == nil {
+				t.Fatal("no error")
+			}
+			if tt.want != nil && !errors.Is(err, tt.want) {
+				t.Errorf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestVariantAndRendition(t *testing.T) {
+	pl := Playlist{
+		Variants: []Variant{{URI: "low", Bandwidth: 100}, {URI: "high", Bandwidth: 300}, {URI: "mid", Bandwidth: 200}},
+		Renditions: []Rendition{
+			{Type: "AUDIO", GroupID: "a", Name: "muxed"},
+			{Type: "SUBTITLES", GroupID: "a", URI: "subs", Default: true},
+			{Type: "AUDIO", GroupID: "a", URI: "first"},
+			{Type: "AUDIO", GroupID: "a", URI: "default", Default: true},
+			{Type: "AUDIO", GroupID: "b", URI: "other"},
+		},
+	}
+	if v, ok := pl.BestVariant(); !ok || v.URI != "high" {
+		t.Errorf("best variant %q, want high", v.URI)
+	}
+	if r, ok := pl.AudioRendition("a"); !ok || r.URI != "default" {
+		t.Errorf("audio of group a %q, want default", r.URI)
+	}
+	if r, ok := pl.AudioRendition("b"); !ok || r.URI != "other" {
+		t.Errorf("audio of group b %q, want other", r.URI)
+	}
+	if _, ok := pl.AudioRendition("c"); ok {
+		t.Error("found audio for a group without renditions")
+	}
+}
@@ -0,0 +1,95 @@
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Options configures the process-wide logger
+type Options struct {
+	// Format is "text" (default) or "json"
+	Format string
+	// File receives the log output instead of stderr when set
+	File string
+	// Level is the minimum level written
+	Level slog.Level
+}
+
+// LevelFromFlags maps the --quiet/--verbose switches to a level
+func LevelFromFlags(quiet bool, verbose bool) slog.Level {
+	switch {
+	case verbose:
+		return slog.LevelDebug
+	case quiet:
+		return slog.LevelWarn
+	default:
+		return slog.LevelInfo
+	}
+}
+
+// Setup builds a logger from opts and installs it as the slog default, which
+// every package logs through. The returned close function flushes and
+// closes the log file, if any.
+func Setup(opts Options) (func() error, error) {
+	var w io.Writer = os.Stderr
+	closeFn := func() error { return nil }
+	if opts.File != "" {
+		f, err := os.OpenFile(opts.File, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("open log file: %w", err)
+		}
+		w, closeFn = f, f.Close
+	}
+
+	handlerOpts := &slog.HandlerOptions{Level: opts.Level, ReplaceAttr: redactAttr}
+	var handler slog.Handler
+	switch strings.ToLower(opts.Format) {
+	case "", "text":
+		handler = slog.NewTextHandler(w, handlerOpts)
+	case "json":
+		handler = slog.NewJSONHandler(w, handlerOpts)
+	default:
+		closeFn()
+		return nil, fmt.Errorf("unknown log format %q, want text or json", opts.Format)
+	}
+
+	slog.SetDefault(slog.New(handler))
+	return closeFn, nil
+}
+
+// sensitiveKeys are header and attribute names whose values never get logged
+var sensitiveKeys = map[string]bool{
+	"cookie":        true,
+	"set-cookie":    true,
+	"authorization": true,
+	"x-csrf-token":  true,
+	"auth_token":    true,
+	"ct0":           true,
+}
+
+const redacted = "[REDACTED]"
+
+func redactAttr(_ []string, a slog.Attr) slog.Attr {
+	if sensitiveKeys[strings.ToLower(a.Key)] {
+		return slog.String(a.Key, redacted)
+	}
+	return a
+}
+
+// RedactHeaders returns the headers as a log-friendly map with credentials
+// replaced
+func RedactHeaders(h http.Header) map[string]string {
+	out := make(map[string]string, len(h))
+	for k, v := range h {
+		if sensitiveKeys[strings.ToLower(k)] {
+			out[k] = redacted
+			continue
+		}
+		out[k] = strings.Join(v, ", ")
+	}
+	return out
+}
@@ -0,0 +1,72 @@
+package progress
+
+import "time"
+
+// Kind identifies what an Event reports
+type Kind int
+
+const (
+	// UserStarted and UserFinished bracket the crawl of one user
+	UserStarted Kind = iota
+	UserFinished
+	// PageCrawled reports a timeline page; Count is the page number
+	PageCrawled
+	// MediaDiscovered reports media found on a page; Count is how many
+	MediaDiscovered
+	// MediaQueued reports a media item that is not in the ledger yet
+	MediaQueued
+	// MediaDone reports a saved media item; Bytes is its size
+	MediaDone
+	// MediaSkipped reports an item that is not downloaded; Reason says why
+	MediaSkipped
+	// MediaFailed reports an item that could not be downloaded; Err says why
+	MediaFailed
+	// FileStarted, FileProgress and FileDone follow a streamed file. Bytes
+	// is the running total, Total the expected size or 0 when unknown and
+	// Fraction the completed share when it can be told apart from bytes
+	// (e.g. HLS segments).
+	FileStarted
+	FileProgress
+	FileDone
+	// MediaPlanned reports an item a dry run would download; Path is where
+	// it would be saved and Bytes its estimated size, 0 when unknown
+	MediaPlanned
+)
+
+// Event is emitted by the crawler and downloader as work progresses
+type Event struct {
+	Kind     Kind
+	Time     time.Time
+	User     string
+	URL      string
+	Count    int
+	Bytes    int64
+	Total    int64
+	Fraction float64
+	Reason   string
+	Err      error
+	// Mode is the sync mode a user is crawled with, set on UserStarted
+	Mode string
+	Path string
+}
+
+// Sink receives events. Implementations must be safe for concurrent use.
+type Sink interface {
+	Emit(Event)
+}
+
+// SinkFunc adapts a function to a Sink
+type SinkFunc func(Event)
+
+func (f SinkFunc) Emit(e Event) { f(e) }
+
+// Multi fans events out to several sinks
+type Multi []Sink
+
+func (m Multi) Emit(e Event) {
+	for _, s := range m {
+		if s != nil {
+			s.Emit(e)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package progress
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	ttyInterval   = 200 * time.Millisecond
+	plainInterval = 10 * time.Second
+	maxFileBars   = 5
+	barWidth      = 24
+)
+
+// Renderer periodically draws a Tracker's state. On a terminal it redraws a
+// block of status lines in place, with a bar per streamed file; otherwise it
+// prints one plain summary line per interval.
+type Renderer struct {
+	w        io.Writer
+	tracker  *Tracker
+	tty      bool
+	interval time.Duration
+
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+
+	drawn     int
+	lastBytes int64
+	lastTime  time.Time
+	rate      float64
+}
+
+// NewRenderer creates a Renderer writing to f, detecting whether f is a terminal
+func NewRenderer(f *os.File, tracker *Tracker) *Renderer {
+	tty := false
+	if info, err := f.Stat(); err == nil {
+		tty = info.Mode()&os.ModeCharDevice != 0
+	}
+	return newRenderer(f, tracker, tty)
+}
+
+func newRenderer(w io.Writer, tracker *Tracker, tty bool) *Renderer {
+	interval := plainInterval
+	if tty {
+		interval = ttyInterval
+	}
+	return &Renderer{
+		w:        w,
+		tracker:  tracker,
+		tty:      tty,
+		interval: interval,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// Start begins rendering in the background
+func (r *Renderer) Start() {
+	go func() {
+		defer close(r.done)
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				r.render()
+			case <-r.stop:
+				r.render()
+				return
+			}
+		}
+	}()
+}
+
+// Stop draws the final state and waits for the background loop to exit
+func (r *Renderer) Stop() {
+	r.stopOnce.Do(func() { close(r.stop) })
+	<-r.done
+}
+
+func (r *Renderer) render() {
+	snap := r.tracker.Snapshot()
+	r.updateRate(snap.LiveBytes)
+
+	lines := []string{r.statusLine(snap)}
+	if r.tty {
+		for i, f := range snap.Active {
+			if i == maxFileBars {
+				lines = append(lines, fmt.Sprintf("  ... %d more files", len(snap.Active)-maxFileBars))
+				break
+			}
+			lines = append(lines, fileLine(f))
+		}
+		// move back over the previous block and clear it before redrawing
+		if r.drawn > 0 {
+			fmt.Fprintf(r.w, "\033[%dA", r.drawn)
+		}
+		for _, line := range lines {
+			fmt.Fprintf(r.w, "\033[2K%s\n", line)
+		}
+		for i := len(lines); i < r.drawn; i++ {
+			fmt.Fprint(r.w, "\033[2K\n")
+		}
+		if r.drawn > len(lines) {
+			fmt.Fprintf(r.w, "\033[%dA", r.drawn-len(lines))
+		}
+		r.drawn = len(lines)
+		return
+	}
+	fmt.Fprintln(r.w, lines[0])
+}
+
+func (r *Renderer) updateRate(bytes int64) {
+	now := time.Now()
+	if !r.lastTime.IsZero() {
+		if dt := now.Sub(r.lastTime).Seconds(); dt > 0 {
+			sample := float64(bytes-r.lastBytes) / dt
+			// smooth over roughly the last few seconds
+			r.rate = 0.7*r.rate + 0.3*sample
+		}
+	}
+	r.lastBytes, r.lastTime = bytes, now
+}
+
+func (r *Renderer) statusLine(snap Snapshot) string {
+	t := snap.Total
+	user := "-"
+	pages := 0
+	if snap.Current != "" {
+		user = "@" + snap.Current
+		for _, u := range snap.Users {
+			if u.User == snap.Current {
+				pages = u.Pages
+			}
+		}
+	}
+	return fmt.Sprintf("%s page %d | discovered %d queued %d done %d skipped %d failed %d | %s %s/s | %s",
+		user, pages, t.Discovered, t.Queued, t.Done, t.Skipped, t.Failed,
+		FormatBytes(snap.LiveBytes), FormatBytes(int64(r.rate)), snap.Elapsed.Truncate(time.Second))
+}
+
+func fileLine(f FileState) string {
+	fraction := f.Fraction
+	if fraction == 0 && f.Total > 0 {
+		fraction = float64(f.Bytes) / float64(f.Total)
+	}
+	if fraction > 1 {
+		fraction = 1
+	}
+	filled := int(fraction * barWidth)
+	bar := strings.Repeat("=", filled) + strings.Repeat(" ", barWidth-filled)
+	size := FormatBytes(f.Bytes)
+	if f.Total > 0 {
+		size += "/" + FormatBytes(f.Total)
+	}
+	return fmt.Sprintf("  [%s] %3.0f%% %s %s", bar, fraction*100, size, shortName(f.URL))
+}
+
+func shortName(url string) string {
+	if i := strings.LastIndex(url, "/"); i >= 0 {
+		url = url[i+1:]
+	}
+	if i := strings.IndexAny(url, "?#"); i >= 0 {
+		url = url[:i]
+	}
+	return url
+}
+
+// FormatBytes renders n with a binary unit, e.g. 1.5 MiB
+func FormatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,176 @@
+package progress
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Stats are the counters of one user, or of the whole run
+type Stats struct {
+	User       string
+	Mode       string
+	Pages      int
+	Discovered int
+	Queued     int
+	Done       int
+	Skipped    int
+	// SkipReasons counts skipped media by reason, e.g. "already downloaded"
+	SkipReasons map[string]int
+	Failed      int
+	Bytes       int64
+	// Planned and PlannedBytes count what a dry run would download
+	Planned      int
+	PlannedBytes int64
+	Started      time.Time
+	Finished     time.Time
+}
+
+// clone copies s so the copy shares no map with the tracker
+func (s Stats) clone() Stats {
+	if s.SkipReasons != nil {
+		reasons := make(map[string]int, len(s.SkipReasons))
+		for k, v := range s.SkipReasons {
+			reasons[k] = v
+		}
+		s.SkipReasons = reasons
+	}
+	return s
+}
+
+func (s *Stats) apply(e Event) {
+	switch e.Kind {
+	case PageCrawled:
+		s.Pages++
+	case MediaDiscovered:
+		s.Discovered += e.Count
+	case MediaQueued:
+		s.Queued++
+	case MediaDone:
+		s.Done++
+		s.Bytes += e.Bytes
+	case MediaSkipped:
+		s.Skipped++
+		if s.SkipReasons == nil {
+			s.SkipReasons = make(map[string]int)
+		}
+		s.SkipReasons[e.Reason]++
+	case MediaFailed:
+		s.Failed++
+	case MediaPlanned:
+		s.Planned++
+		s.PlannedBytes += e.Bytes
+	}
+}
+
+// FileState is a file that is currently being streamed
+type FileState struct {
+	URL      string
+	Bytes    int64
+	Total    int64
+	Fraction float64
+	Started  time.Time
+}
+
+// Snapshot is a consistent copy of a Tracker's state
+type Snapshot struct {
+	Current string
+	Users   []Stats
+	Total   Stats
+	// LiveBytes includes the bytes of files still in flight
+	LiveBytes int64
+	Active    []FileState
+	Elapsed   time.Duration
+}
+
+// Tracker aggregates events into per-user and run-wide counters
+type Tracker struct {
+	mu      sync.Mutex
+	now     func() time.Time
+	started time.Time
+	current string
+	order   []string
+	users   map[string]*Stats
+	total   Stats
+	active  map[string]*FileState
+}
+
+// NewTracker creates a Tracker whose run starts now
+func NewTracker() *Tracker {
+	t := &Tracker{now: time.Now, users: make(map[string]*Stats), active: make(map[string]*FileState)}
+	t.started = t.now()
+	t.total.Started = t.started
+	return t
+}
+
+// Emit implements Sink
+func (t *Tracker) Emit(e Event) {
+	if e.Time.IsZero() {
+		e.Time = t.now()
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	stats := t.user(e.User)
+	switch e.Kind {
+	case UserStarted:
+		t.current = e.User
+		if stats != nil {
+			stats.Started, stats.Mode = e.Time, e.Mode
+		}
+	case UserFinished:
+		if stats != nil {
+			stats.Finished = e.Time
+		}
+	case FileStarted:
+		t.active[e.URL] = &FileState{URL: e.URL, Total: e.Total, Started: e.Time}
+	case FileProgress:
+		if f, ok := t.active[e.URL]; ok {
+			f.Bytes, f.Fraction = e.Bytes, e.Fraction
+			if e.Total > 0 {
+				f.Total = e.Total
+			}
+		}
+	case FileDone:
+		delete(t.active, e.URL)
+	}
+	if stats != nil {
+		stats.apply(e)
+	}
+	t.total.apply(e)
+}
+
+func (t *Tracker) user(name string) *Stats {
+	if name == "" {
+		return nil
+	}
+	stats, ok := t.users[name]
+	if !ok {
+		stats = &Stats{User: name}
+		t.users[name] = stats
+		t.order = append(t.order, name)
+	}
+	return stats
+}
+
+// Snapshot returns the current counters
+func (t *Tracker) Snapshot() Snapshot {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	snap := Snapshot{
+		Current: t.current,
+		Total:   t.total.clone(),
+		Elapsed: t.now().Sub(t.started),
+	}
+	for _, name := range t.order {
+		snap.Users = append(snap.Users, t.users[name].clone())
+	}
+	snap.LiveBytes = t.total.Bytes
+	for _, f := range t.active {
+		snap.Active = append(snap.Active, *f)
+		snap.LiveBytes += f.Bytes
+	}
+	sort.Slice(snap.Active, func(i, j int) bool { return snap.Active[i].Started.Before(snap.Active[j].Started) })
+	return snap
+}
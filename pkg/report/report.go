@@ -0,0 +1,145 @@
+package report
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// Stage names the step of the pipeline an error happened in
+type Stage string
+
+const (
+	StageUser      Stage = "user"
+	StageProfile   Stage = "profile"
+	StageTimeline  Stage = "timeline"
+	StageParse     Stage = "parse"
+	StageDownload  Stage = "download"
+	StageSave      Stage = "save"
+	StageLedger    Stage = "ledger"
+	StageCSV       Stage = "csv"
+	StageState     Stage = "state"
+	StageThumbnail Stage = "thumbnail"
+)
+
+// Error is a pipeline error annotated with where it happened
+type Error struct {
+	Stage   Stage
+	User    string
+	TweetId string
+	URL     string
+	Err     error
+}
+
+func (e *Error) Error() string {
+	var sb strings.Builder
+	if e.Stage != "" {
+		sb.WriteString("[" + string(e.Stage) + "]")
+	}
+	if e.User != "" {
+		sb.WriteString(" user=" + e.User)
+	}
+	if e.TweetId != "" {
+		sb.WriteString(" tweet=" + e.TweetId)
+	}
+	if e.URL != "" {
+		sb.WriteString(" url=" + e.URL)
+	}
+	sb.WriteString(": ")
+	sb.WriteString(e.Err.Error())
+	return sb.String()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Wrap annotates err with its stage and context. When err already is an
+// *Error its stage is kept and only the empty context fields are filled in,
+// so inner stages can be more specific than their callers. Wrap returns nil
+// for a nil err.
+func Wrap(err error, stage Stage, user string, tweetId string, url string) error {
+	if err == nil {
+		return nil
+	}
+	var e *Error
+	if errors.As(err, &e) {
+		wrapped := *e
+		if wrapped.User == "" {
+			wrapped.User = user
+		}
+		if wrapped.TweetId == "" {
+			wrapped.TweetId = tweetId
+		}
+		if wrapped.URL == "" {
+			wrapped.URL = url
+		}
+		return &wrapped
+	}
+	return &Error{Stage: stage, User: user, TweetId: tweetId, URL: url, Err: err}
+}
+
+// Report collects the errors of one run. It is safe for concurrent use.
+type Report struct {
+	mu     sync.Mutex
+	errors []*Error
+}
+
+// NewReport creates an empty Report
+func NewReport() *Report {
+	return &Report{}
+}
+
+// Add records err. Errors that are not an *Error are recorded without
+// context; nil is ignored.
+func (r *Report) Add(err error) {
+	if err == nil {
+		return
+	}
+	var e *Error
+	if !errors.As(err, &e) {
+		e = &Error{Err: err}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.errors = append(r.errors, e)
+}
+
+// Errors returns a copy of the recorded errors
+func (r *Report) Errors() []*Error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]*Error(nil), r.errors...)
+}
+
+// Failed reports whether any error was recorded
+func (r *Report) Failed() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.errors) > 0
+}
+
+// Print writes the recorded errors grouped by stage
+func (r *Report) Print(w io.Writer) {
+	errs := r.Errors()
+	if len(errs) == 0 {
+		return
+	}
+	byStage := make(map[Stage][]*Error)
+	var stages []Stage
+	for _, e := range errs {
+		if _, ok := byStage[e.Stage]; !ok {
+			stages = append(stages, e.Stage)
+		}
+		byStage[e.Stage] = append(byStage[e.Stage], e)
+	}
+	fmt.Fprintf(w, "%d error(s):\n", len(errs))
+	for _, stage := range stages {
+		fmt.Fprintf(w, "  %s (%d)\n", stage, len(byStage[stage]))
+		for _, e := range byStage[stage] {
+			fmt.Fprintf(w, "    %v\n", e)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"twitterDownload/pkg/progress"
+)
+
+// Failure is one error of a summary, flattened for JSON
+type Failure struct {
+	Stage   Stage  `json:"stage"`
+	TweetId string `json:"tweetId,omitempty"`
+	URL     string `json:"url,omitempty"`
+	Reason  string `json:"reason"`
+}
+
+// UserSummary is the outcome of crawling one user
+type UserSummary struct {
+	User           string         `json:"user"`
+	Mode           string         `json:"mode,omitempty"`
+	NewFiles       int            `json:"newFiles"`
+	Skipped        int            `json:"skipped"`
+	SkipReasons    map[string]int `json:"skipReasons,omitempty"`
+	Failed         int            `json:"failed"`
+	Failures       []Failure      `json:"failures,omitempty"`
+	Bytes          int64          `json:"bytes"`
+	Planned        int            `json:"planned,omitempty"`
+	PlannedBytes   int64          `json:"plannedBytes,omitempty"`
+	Pages          int            `json:"pages"`
+	ElapsedSeconds float64        `json:"elapsedSeconds"`
+}
+
+// Summary is the outcome of a whole run
+type Summary struct {
+	StartedAt      time.Time     `json:"startedAt"`
+	FinishedAt     time.Time     `json:"finishedAt"`
+	ElapsedSeconds float64       `json:"elapsedSeconds"`
+	Users          []UserSummary `json:"users"`
+	Total          UserSummary   `json:"total"`
+	// Failures holds errors that are not tied to a user
+	Failures []Failure `json:"failures,omitempty"`
+}
+
+// NewSummary combines the progress counters of a run with its errors
+func NewSummary(snap progress.Snapshot, errs []*Error, finishedAt time.Time) Summary {
+	summary := Summary{
+		StartedAt:      snap.Total.Started,
+		FinishedAt:     finishedAt,
+		ElapsedSeconds: finishedAt.Sub(snap.Total.Started).Seconds(),
+	}
+
+	index := make(map[string]int)
+	for _, stats := range snap.Users {
+		index[stats.User] = len(summary.Users)
+		summary.Users = append(summary.Users, fromStats(stats))
+	}
+
+	for _, e := range errs {
+		failure := Failure{Stage: e.Stage, TweetId: e.TweetId, URL: e.URL, Reason: e.Err.Error()}
+		if e.User == "" {
+			summary.Failures = append(summary.Failures, failure)
+			continue
+		}
+		i, ok := index[e.User]
+		if !ok {
+			// the user failed before crawling started, e.g. it does not exist
+			i = len(summary.Users)
+			index[e.User] = i
+			summary.Users = append(summary.Users, UserSummary{User: e.User})
+		}
+		summary.Users[i].Failures = append(summary.Users[i].Failures, failure)
+	}
+
+	summary.Total = fromStats(snap.Total)
+	summary.Total.User = "total"
+	summary.Total.ElapsedSeconds = summary.ElapsedSeconds
+	return summary
+}
+
+func fromStats(stats progress.Stats) UserSummary {
+	s := UserSummary{
+		User:         stats.User,
+		Mode:         stats.Mode,
+		NewFiles:     stats.Done,
+		Skipped:      stats.Skipped,
+		SkipReasons:  stats.SkipReasons,
+		Failed:       stats.Failed,
+		Bytes:        stats.Bytes,
+		Planned:      stats.Planned,
+		PlannedBytes: stats.PlannedBytes,
+		Pages:        stats.Pages,
+	}
+	if !stats.Started.IsZero() && !stats.Finished.IsZero() {
+		s.ElapsedSeconds = stats.Finished.Sub(stats.Started).Seconds()
+	}
+	return s
+}
+
+// Failed reports whether anything in the run failed
+func (s Summary) Failed() bool {
+	if len(s.Failures) > 0 || s.Total.Failed > 0 {
+		return true
+	}
+	for _, u := range s.Users {
+		if len(u.Failures) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// PrintTable writes the summary as a table followed by the failure reasons
+func (s Summary) PrintTable(w io.Writer) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "user\tmode\tnew\tskipped\tfailed\tbytes\tpages\telapsed\t")
+	row := func(u UserSummary) {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%d\t%s\t%d\t%s\t\n", u.User, u.Mode, u.NewFiles, u.Skipped, u.Failed,
+			progress.FormatBytes(u.Bytes), u.Pages, formatSeconds(u.ElapsedSeconds))
+	}
+	for _, u := range s.Users {
+		row(u)
+	}
+	row(s.Total)
+	tw.Flush()
+
+	if s.Total.Planned > 0 {
+		for _, u := range append(s.Users, s.Total) {
+			fmt.Fprintf(w, "%s would download %d files, about %s\n", u.User, u.Planned, progress.FormatBytes(u.PlannedBytes))
+		}
+	}
+
+	for _, u := range s.Users {
+		if len(u.SkipReasons) > 0 {
+			reasons := make([]string, 0, len(u.SkipReasons))
+			for reason := range u.SkipReasons {
+				reasons = append(reasons, reason)
+			}
+			sort.Strings(reasons)
+			fmt.Fprintf(w, "%s skipped:\n", u.User)
+			for _, reason := range reasons {
+				fmt.Fprintf(w, "  %d  %s\n", u.SkipReasons[reason], reason)
+			}
+		}
+		if len(u.Failures) > 0 {
+			fmt.Fprintf(w, "%s failed:\n", u.User)
+			printFailures(w, u.Failures)
+		}
+	}
+	if len(s.Failures) > 0 {
+		fmt.Fprintln(w, "run failed:")
+		printFailures(w, s.Failures)
+	}
+}
+
+func printFailures(w io.Writer, failures []Failure) {
+	for _, f := range failures {
+		line := "  [" + string(f.Stage) + "]"
+		if f.TweetId != "" {
+			line += " tweet=" + f.TweetId
+		}
+		if f.URL != "" {
+			line += " url=" + f.URL
+		}
+		fmt.Fprintf(w, "%s: %s\n", line, f.Reason)
+	}
+}
+
+func formatSeconds(seconds float64) string {
+	return (time.Duration(seconds * float64(time.Second))).Truncate(time.Second).String()
+}
+
+// WriteJSON saves the summary as run-<timestamp>.json in dir and returns
+// the path written
+func (s Summary) WriteJSON(dir string) (string, error) {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, "run-"+s.StartedAt.Format("20060102-150405")+".json")
+	return path, os.WriteFile(path, data, 0644)
+}
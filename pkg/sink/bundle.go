@@ -0,0 +1,386 @@
+package sink
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// ManifestName is the bundle entry listing every other entry with its hash
+const ManifestName = "manifest.json"
+
+// Manifest describes the entries of a bundle
+type Manifest struct {
+	Updated time.Time                `json:"updated"`
+	Files   map[string]ManifestEntry `json:"files"`
+}
+
+// ManifestEntry is one file of a bundle
+type ManifestEntry struct {
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// Appender is implemented by sinks that collect a file from pieces, such as
+// the rows of a CSV written over several runs
+type Appender interface {
+	// Append adds data to the end of the file at path. header goes first
+	// when the file does not exist yet.
+	Append(path string, data []byte, header []byte) error
+}
+
+// Bundle writes the files of each user folder under Root into one archive
+// next to it, <folder>.zip, <folder>.tar, <folder>.tar.gz or
+// <folder>.tar.zst, instead of loose files. Entries are named by their
+// path inside the folder.
+//
+// New entries are written to a temporary archive. Close then copies over
+// the entries of the existing archive that were not replaced, adds the
+// manifest and swaps the archives, so incremental runs keep what earlier
+// runs stored.
+type Bundle struct {
+	Root string
+	// Format is zip, tar, tar.gz or tar.zst
+	Format string
+
+	mu    sync.Mutex
+	files map[string]*bundleFile
+}
+
+// bundleFile is one archive being written
+type bundleFile struct {
+	mu       sync.Mutex
+	path     string
+	format   string
+	part     *os.File
+	zip      *zip.Writer
+	gzip     *gzip.Writer
+	zstd     *zstd.Encoder
+	tar      *tar.Writer
+	manifest map[string]ManifestEntry
+	// put are the entries Put during this run
+	put      []string
+	appended map[string][]byte
+	headers  map[string][]byte
+}
+
+// NewBundle creates a bundle sink for the output folder root
+func NewBundle(root string, format string) (*Bundle, error) {
+	switch format {
+	case "":
+		format = "zip"
+	case "zip", "tar", "tar.gz", "tar.zst":
+	default:
+		return nil, fmt.Errorf("bundle format %q is not zip, tar, tar.gz or tar.zst", format)
+	}
+	return &Bundle{Root: root, Format: format, files: make(map[string]*bundleFile)}, nil
+}
+
+// split finds the archive of path and the entry name inside it
+func (b *Bundle) split(path string) (archive string, name string) {
+	key := relativeKey(b.Root, path)
+	folder, name, ok := strings.Cut(key, "/")
+	if !ok {
+		folder, name = "files", key
+	}
+	return filepath.Join(b.Root, folder+"."+b.Format), name
+}
+
+func (b *Bundle) open(archive string) (*bundleFile, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if f, ok := b.files[archive]; ok {
+		return f, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(archive), 0755); err != nil {
+		return nil, err
+	}
+	part, err := os.Create(archive + ".part")
+	if err != nil {
+		return nil, err
+	}
+	f := &bundleFile{
+		path:     archive,
+		format:   b.Format,
+		part:     part,
+		manifest: make(map[string]ManifestEntry),
+		appended: make(map[string][]byte),
+		headers:  make(map[string][]byte),
+	}
+	switch b.Format {
+	case "zip":
+		f.zip = zip.NewWriter(part)
+	case "tar.gz":
+		f.gzip = gzip.NewWriter(part)
+		f.tar = tar.NewWriter(f.gzip)
+	case "tar.zst":
+		if f.zstd, err = zstd.NewWriter(part); err != nil {
+			part.Close()
+			os.Remove(part.Name())
+			return nil, err
+		}
+		f.tar = tar.NewWriter(f.zstd)
+	default:
+		f.tar = tar.NewWriter(part)
+	}
+	b.files[archive] = f
+	return f, nil
+}
+
+// Put writes body as an entry of the user's archive and returns
+// <archive>!<entry>
+func (b *Bundle) Put(ctx context.Context, path string, body io.Reader, size int64) (string, error) {
+	archive, name := b.split(path)
+	f, err := b.open(archive)
+	if err != nil {
+		return "", err
+	}
+	if err := f.write(name, body, size, time.Now()); err != nil {
+		return "", err
+	}
+	f.mu.Lock()
+	f.put = append(f.put, name)
+	f.mu.Unlock()
+	return archive + "!" + name, nil
+}
+
+// Append collects data for the entry of path, it is written on Close
+func (b *Bundle) Append(path string, data []byte, header []byte) error {
+	archive, name := b.split(path)
+	f, err := b.open(archive)
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.appended[name] = append(f.appended[name], data...)
+	f.headers[name] = header
+	return nil
+}
+
+// CommitError is returned by Bundle.Close when archives could not be
+// replaced. They keep their old entries; the locations Put returned for
+// this run's entries, listed in Lost, point at nothing.
+type CommitError struct {
+	Lost []string
+	Err  error
+}
+
+func (e *CommitError) Error() string { return e.Err.Error() }
+
+func (e *CommitError) Unwrap() error { return e.Err }
+
+// Close finishes every archive written to. The error is a *CommitError when
+// any of them failed.
+func (b *Bundle) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var errs []error
+	var lost []string
+	for archive, f := range b.files {
+		if err := f.finish(); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", archive, err))
+			for _, name := range f.put {
+				lost = append(lost, archive+"!"+name)
+			}
+		}
+	}
+	b.files = make(map[string]*bundleFile)
+	if len(errs) == 0 {
+		return nil
+	}
+	return &CommitError{Lost: lost, Err: errors.Join(errs...)}
+}
+
+// write adds one entry, hashing it on the way
+func (f *bundleFile) write(name string, body io.Reader, size int64, modified time.Time) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.manifest[name]; ok {
+		return fmt.Errorf("%s is already in the bundle", name)
+	}
+
+	var w io.Writer
+	if f.zip != nil {
+		var err error
+		if w, err = f.zip.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: modified}); err != nil {
+			return err
+		}
+	} else {
+		if err := f.tar.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: size, ModTime: modified, Typeflag: tar.TypeReg}); err != nil {
+			return err
+		}
+		w = f.tar
+	}
+
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(w, h), body)
+	if err != nil {
+		return err
+	}
+	if n != size {
+		return fmt.Errorf("%s: wrote %d bytes, expected %d", name, n, size)
+	}
+	f.manifest[name] = ManifestEntry{Size: n, SHA256: hex.EncodeToString(h.Sum(nil))}
+	return nil
+}
+
+// finish copies the old archive's entries that were not replaced, writes
+// the appended entries after their old content, adds the manifest and
+// replaces the old archive
+func (f *bundleFile) finish() error {
+	previous := make(map[string][]byte)
+	err := eachEntry(f.path, f.format, func(name string, modified time.Time, size int64, r io.Reader) error {
+		if _, ok := f.appended[name]; ok {
+			data, err := io.ReadAll(r)
+			previous[name] = data
+			return err
+		}
+		if _, replaced := f.manifest[name]; replaced || name == ManifestName {
+			return nil
+		}
+		return f.write(name, r, size, modified)
+	})
+	if err != nil && !os.IsNotExist(err) {
+		f.abort()
+		return err
+	}
+
+	names := make([]string, 0, len(f.appended))
+	for name := range f.appended {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		data, ok := previous[name]
+		if !ok {
+			data = f.headers[name]
+		}
+		data = append(append([]byte(nil), data...), f.appended[name]...)
+		if err := f.write(name, bytes.NewReader(data), int64(len(data)), time.Now()); err != nil {
+			f.abort()
+			return err
+		}
+	}
+
+	manifest, err := json.MarshalIndent(Manifest{Updated: time.Now(), Files: f.manifest}, "", "  ")
+	if err != nil {
+		f.abort()
+		return err
+	}
+	if err := f.write(ManifestName, bytes.NewReader(manifest), int64(len(manifest)), time.Now()); err != nil {
+		f.abort()
+		return err
+	}
+
+	if err := f.close(); err != nil {
+		os.Remove(f.part.Name())
+		return err
+	}
+	return os.Rename(f.part.Name(), f.path)
+}
+
+func (f *bundleFile) close() error {
+	var errs []error
+	if f.zip != nil {
+		errs = append(errs, f.zip.Close())
+	}
+	if f.tar != nil {
+		errs = append(errs, f.tar.Close())
+	}
+	if f.gzip != nil {
+		errs = append(errs, f.gzip.Close())
+	}
+	if f.zstd != nil {
+		errs = append(errs, f.zstd.Close())
+	}
+	errs = append(errs, f.part.Close())
+	return errors.Join(errs...)
+}
+
+// abort drops the temporary archive, leaving the old one untouched
+func (f *bundleFile) abort() {
+	f.close()
+	os.Remove(f.part.Name())
+}
+
+// eachEntry streams the regular files of an existing archive to fn
+func eachEntry(path string, format string, fn func(name string, modified time.Time, size int64, r io.Reader) error) error {
+	if format == "zip" {
+		r, err := zip.OpenReader(path)
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		for _, file := range r.File {
+			if file.FileInfo().IsDir() {
+				continue
+			}
+			rc, err := file.Open()
+			if err != nil {
+				return err
+			}
+			err = fn(file.Name, file.Modified, int64(file.UncompressedSize64), rc)
+			rc.Close()
+			if err != nil {
+				return fmt.Errorf("%s: %w", file.Name, err)
+			}
+		}
+		return nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	var r io.Reader = file
+	switch format {
+	case "tar.gz":
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	case "tar.zst":
+		zr, err := zstd.NewReader(file)
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		r = zr
+	}
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		if err := fn(header.Name, header.ModTime, header.Size, tr); err != nil {
+			return fmt.Errorf("%s: %w", header.Name, err)
+		}
+	}
+}
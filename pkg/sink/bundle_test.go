@@ -0,0 +1,109 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBundleKeepsEarlierRuns(t *testing.T) {
+	for _, format := range []string{"zip", "tar", "tar.gz", "tar.zst"} {
+		t.Run(format, func(t *testing.T) {
+			root := t.TempDir()
+			runs := []struct {
+				file string
+				row  string
+			}{
+				{"a.jpg", "1,a.jpg\n"},
+				{"b.mp4", "2,b.mp4\n"},
+			}
+			for _, run := range runs {
+				b, err := NewBundle(root, format)
+				if err != nil {
+					t.Fatal(err)
+				}
+				body := strings.Repeat(run.file, 1000)
+				location, err := b.Put(context.Background(), filepath.Join(root, "123", run.file), strings.NewReader(body), int64(len(body)))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if want := filepath.Join(root, "123."+format) + "!" + run.file; location != want {
+					t.Errorf("location %q, want %q", location, want)
+				}
+				if err := b.Append(filepath.Join(root, "123", "record.csv"), []byte(run.row), []byte("id,file\n")); err != nil {
+					t.Fatal(err)
+				}
+				if err := b.Close(); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			entries := make(map[string]string)
+			err := eachEntry(filepath.Join(root, "123."+format), format, func(name string, modified time.Time, size int64, r io.Reader) error {
+				data, err := io.ReadAll(r)
+				entries[name] = string(data)
+				return err
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := entries["a.jpg"], strings.Repeat("a.jpg", 1000); got != want {
+				t.Errorf("a.jpg holds %d bytes, want %d", len(got), len(want))
+			}
+			if got, want := entries["record.csv"], "id,file\n1,a.jpg\n2,b.mp4\n"; got != want {
+				t.Errorf("record.csv = %q, want %q", got, want)
+			}
+			var manifest Manifest
+			if err := json.Unmarshal([]byte(entries[ManifestName]), &manifest); err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != 4 || len(manifest.Files) != 3 {
+				t.Errorf("%d entries with %d in the manifest, want 4 and 3", len(entries), len(manifest.Files))
+			}
+			if got := manifest.Files["b.mp4"].Size; got != 5000 {
+				t.Errorf("manifest size of b.mp4 is %d, want 5000", got)
+			}
+		})
+	}
+}
+
+func TestBundleCloseReportsLostEntries(t *testing.T) {
+	root := t.TempDir()
+	// a folder where the archive goes cannot be replaced
+	if err := os.Mkdir(filepath.Join(root, "123.zip"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewBundle(root, "zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"123/a.jpg", "123/b.jpg", "456/c.jpg"} {
+		if _, err := b.Put(context.Background(), filepath.Join(root, filepath.FromSlash(path)), strings.NewReader(path), int64(len(path))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var commitErr *CommitError
+	if err := b.Close(); !errors.As(err, &commitErr) {
+		t.Fatalf("got %v, want a CommitError", err)
+	}
+	sort.Strings(commitErr.Lost)
+	archive := filepath.Join(root, "123.zip")
+	if want := []string{archive + "!a.jpg", archive + "!b.jpg"}; !reflect.DeepEqual(commitErr.Lost, want) {
+		t.Errorf("lost %q, want %q", commitErr.Lost, want)
+	}
+	if _, err := os.Stat(archive + ".part"); !os.IsNotExist(err) {
+		t.Errorf("temporary archive left behind: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "456.zip")); err != nil {
+		t.Errorf("the other archive was not finished: %v", err)
+	}
+}
@@ -0,0 +1,44 @@
+package sink
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Local writes files to the local disk at their path
+type Local struct{}
+
+// Put writes body to a .part file next to path and renames it once
+// complete, so an interrupted write never leaves a truncated file behind
+func (Local) Put(ctx context.Context, path string, body io.Reader, size int64) (string, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", err
+	}
+	partPath := path + ".part"
+	f, err := os.Create(partPath)
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(f, body)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = ctx.Err()
+	}
+	if err != nil {
+		os.Remove(partPath)
+		return "", err
+	}
+	return path, os.Rename(partPath, path)
+}
+
+// Move renames a finished local file into place
+func (Local) Move(ctx context.Context, from string, path string) (string, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", err
+	}
+	return path, os.Rename(from, path)
+}
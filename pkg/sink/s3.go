@@ -0,0 +1,140 @@
+package sink
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"twitterDownload/pkg/config"
+)
+
+// S3 uploads files to a bucket of an S3-compatible object store such as AWS
+// S3 or MinIO. Requests use path-style addressing and are signed with
+// Signature Version 4 over an unsigned payload, so bodies are streamed.
+type S3 struct {
+	Config config.S3Config
+	// Root is the output folder, object keys are Config.Prefix followed by
+	// the file's path relative to it
+	Root   string
+	Client *http.Client
+}
+
+// Put uploads body as the object for path and returns s3://<bucket>/<key>
+func (s *S3) Put(ctx context.Context, filePath string, body io.Reader, size int64) (string, error) {
+	key := strings.Trim(s.Config.Prefix, "/")
+	if key != "" {
+		key += "/"
+	}
+	key += relativeKey(s.Root, filePath)
+
+	endpoint, err := url.Parse(s.Config.Endpoint)
+	if err != nil {
+		return "", err
+	}
+	objectUrl := *endpoint
+	objectUrl.Path = strings.TrimSuffix(endpoint.Path, "/") + "/" + s.Config.Bucket + "/" + key
+	objectUrl.RawPath = strings.TrimSuffix(endpoint.EscapedPath(), "/") + "/" + uriEncode(s.Config.Bucket) + "/" + uriEncodePath(key)
+
+	if size == 0 {
+		// a zero length with a body would be sent chunked, which S3 refuses
+		body = http.NoBody
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, objectUrl.String(), body)
+	if err != nil {
+		return "", err
+	}
+	req.ContentLength = size
+	if contentType := mime.TypeByExtension(path.Ext(key)); contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	s.sign(req)
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return "", fmt.Errorf("put s3://%s/%s: %s %s", s.Config.Bucket, key, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return "s3://" + s.Config.Bucket + "/" + key, nil
+}
+
+// sign adds the Signature Version 4 authorization header
+func (s *S3) sign(req *http.Request) {
+	t := time.Now().UTC()
+	amzDate := t.Format("20060102T150405Z")
+	day := t.Format("20060102")
+	region := s.Config.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", "UNSIGNED-PAYLOAD")
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		"host:" + req.URL.Host,
+		"x-amz-content-sha256:UNSIGNED-PAYLOAD",
+		"x-amz-date:" + amzDate,
+		"",
+		signedHeaders,
+		"UNSIGNED-PAYLOAD",
+	}, "\n")
+
+	scope := day + "/" + region + "/s3/aws4_request"
+	hash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+
+	key := hmacSHA256([]byte("AWS4"+s.Config.SecretKey), day)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.Config.AccessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// uriEncode escapes everything but the unreserved characters of RFC 3986,
+// as Signature Version 4 requires
+func uriEncode(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || strings.IndexByte("-_.~", c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// uriEncodePath escapes each segment of a slash separated key
+func uriEncodePath(key string) string {
+	segments := strings.Split(key, "/")
+	for i, segment := range segments {
+		segments[i] = uriEncode(segment)
+	}
+	return strings.Join(segments, "/")
+}
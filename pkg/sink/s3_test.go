@@ -0,0 +1,150 @@
+package sink
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"twitterDownload/pkg/config"
+)
+
+// the signing key derivation example of the AWS Signature Version 4 docs
+func TestSigningKey(t *testing.T) {
+	key := hmacSHA256([]byte("AWS4wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"), "20120215")
+	key = hmacSHA256(key, "us-east-1")
+	key = hmacSHA256(key, "iam")
+	key = hmacSHA256(key, "aws4_request")
+	if got, want := hex.EncodeToString(key), "f4780e2d9f65fa895f9c67b32ce1baf0b0d8a43505a000a1a9e090d414db404d"; got != want {
+		t.Errorf("signing key %s, want %s", got, want)
+	}
+}
+
+var authorization = regexp.MustCompile(`^AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/(\d{8})/eu-central-1/s3/aws4_request, SignedHeaders=host;x-amz-content-sha256;x-amz-date, Signature=([0-9a-f]{64})$`)
+
+// verifySigV4 checks the signature of a request the way the object store
+// does, from what arrived over the wire. It runs in the server's goroutine
+// so it only reports errors.
+func verifySigV4(t *testing.T, r *http.Request, secret string) {
+	t.Helper()
+	m := authorization.FindStringSubmatch(r.Header.Get("Authorization"))
+	if m == nil {
+		t.Errorf("authorization header %q", r.Header.Get("Authorization"))
+		return
+	}
+	day, signature := m[1], m[2]
+	amzDate := r.Header.Get("x-amz-date")
+	if !strings.HasPrefix(amzDate, day+"T") || len(amzDate) != len("20060102T150405Z") {
+		t.Errorf("x-amz-date %q does not match the credential day %s", amzDate, day)
+	}
+	if got := r.Header.Get("x-amz-content-sha256"); got != "UNSIGNED-PAYLOAD" {
+		t.Errorf("x-amz-content-sha256 %q, want UNSIGNED-PAYLOAD", got)
+	}
+
+	canonical := r.Method + "\n" +
+		r.URL.EscapedPath() + "\n" +
+		r.URL.RawQuery + "\n" +
+		"host:" + r.Host + "\n" +
+		"x-amz-content-sha256:UNSIGNED-PAYLOAD\n" +
+		"x-amz-date:" + amzDate + "\n" +
+		"\n" +
+		"host;x-amz-content-sha256;x-amz-date\n" +
+		"UNSIGNED-PAYLOAD"
+	hash := sha256.Sum256([]byte(canonical))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + day + "/eu-central-1/s3/aws4_request\n" + hex.EncodeToString(hash[:])
+
+	key := []byte("AWS4" + secret)
+	for _, part := range []string{day, "eu-central-1", "s3", "aws4_request"} {
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(part))
+		key = mac.Sum(nil)
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(stringToSign))
+	if want := hex.EncodeToString(mac.Sum(nil)); signature != want {
+		t.Errorf("signature %s, want %s for the canonical request\n%s", signature, want, canonical)
+	}
+}
+
+func TestS3Put(t *testing.T) {
+	type upload struct {
+		path        string
+		body        string
+		contentType string
+	}
+	var uploads []upload
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		verifySigV4(t, r, "secret/KEY")
+		if r.Method != http.MethodPut {
+			t.Errorf("method %s, want PUT", r.Method)
+		}
+		if len(r.TransferEncoding) > 0 {
+			t.Errorf("body sent %v", r.TransferEncoding)
+		}
+		body, _ := io.ReadAll(r.Body)
+		uploads = append(uploads, upload{r.URL.EscapedPath(), string(body), r.Header.Get("Content-Type")})
+	}))
+	defer srv.Close()
+
+	root := t.TempDir()
+	s := &S3{
+		Config: config.S3Config{Endpoint: srv.URL + "/storage/", Bucket: "media", Region: "eu-central-1", Prefix: "/twitter/", AccessKey: "AKIDEXAMPLE", SecretKey: "secret/KEY"},
+		Root:   root,
+		Client: srv.Client(),
+	}
+	files := []struct {
+		path string
+		body string
+	}{
+		{filepath.Join(root, "123", "GMAXMobaYAAk3Ab.jpg"), "jpeg"},
+		{filepath.Join(root, "123", "likes", "名字 (1)+x.mp4"), "video"},
+		{filepath.Join(root, "123", "empty.json"), ""},
+	}
+	for _, f := range files {
+		if _, err := s.Put(context.Background(), f.path, strings.NewReader(f.body), int64(len(f.body))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	location, err := s.Put(context.Background(), filepath.Join(root, "record.csv"), strings.NewReader("id"), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if location != "s3://media/twitter/record.csv" {
+		t.Errorf("location %q", location)
+	}
+
+	want := []upload{
+		{"/storage/media/twitter/123/GMAXMobaYAAk3Ab.jpg", "jpeg", "image/jpeg"},
+		{"/storage/media/twitter/123/likes/%E5%90%8D%E5%AD%97%20%281%29%2Bx.mp4", "video", mime.TypeByExtension(".mp4")},
+		{"/storage/media/twitter/123/empty.json", "", "application/json"},
+		{"/storage/media/twitter/record.csv", "id", mime.TypeByExtension(".csv")},
+	}
+	if len(uploads) != len(want) {
+		t.Fatalf("%d uploads, want %d", len(uploads), len(want))
+	}
+	for i := range want {
+		if uploads[i] != want[i] {
+			t.Errorf("upload %d: got %+v, want %+v", i, uploads[i], want[i])
+		}
+	}
+}
+
+func TestS3PutError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "<Error><Code>AccessDenied</Code></Error>", http.StatusForbidden)
+	}))
+	defer srv.Close()
+	s := &S3{Config: config.S3Config{Endpoint: srv.URL, Bucket: "media"}, Client: srv.Client()}
+	_, err := s.Put(context.Background(), "123/a.jpg", strings.NewReader("x"), 1)
+	if err == nil || !strings.Contains(err.Error(), "403") || !strings.Contains(err.Error(), "AccessDenied") {
+		t.Errorf("got %v, want the status and the message of the store", err)
+	}
+}
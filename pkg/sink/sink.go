@@ -0,0 +1,77 @@
+// Package sink stores downloaded files. The downloader works with local
+// paths under an output folder; a sink decides where a file at such a path
+// really ends up, on the local disk or on a remote server.
+package sink
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"twitterDownload/pkg/config"
+)
+
+// Sink stores files
+type Sink interface {
+	// Put stores size bytes of body as the file at path and returns its
+	// location: the path itself for the local disk, the object key or URL
+	// for remote sinks
+	Put(ctx context.Context, path string, body io.Reader, size int64) (string, error)
+}
+
+// Mover is implemented by sinks that can take over a finished local file
+// without copying it
+type Mover interface {
+	Move(ctx context.Context, from string, path string) (string, error)
+}
+
+// PutFile stores the local file from, e.g. a finished .part download, as
+// path and removes from afterwards
+func PutFile(ctx context.Context, s Sink, from string, path string) (string, error) {
+	if m, ok := s.(Mover); ok {
+		return m.Move(ctx, from, path)
+	}
+	f, err := os.Open(from)
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(from)
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	return s.Put(ctx, path, f, info.Size())
+}
+
+// Open creates the sink selected by cfg for the output folder root. Remote
+// sinks name objects by their path relative to root. Sinks that implement
+// io.Closer must be closed once all files were put.
+func Open(cfg config.StorageConfig, root string, client *http.Client) (Sink, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	switch cfg.Backend {
+	case "", "local":
+		return Local{}, nil
+	case "s3":
+		return &S3{Config: cfg.S3, Root: root, Client: client}, nil
+	case "webdav":
+		return &WebDAV{Config: cfg.WebDAV, Root: root, Client: client}, nil
+	case "bundle":
+		return NewBundle(root, cfg.Bundle.Format)
+	}
+	return nil, fmt.Errorf("storage backend %q is not supported", cfg.Backend)
+}
+
+// relativeKey turns a path under root into a slash separated key
+func relativeKey(root string, path string) string {
+	if rel, err := filepath.Rel(root, path); err == nil && !strings.HasPrefix(rel, "..") {
+		path = rel
+	}
+	return strings.TrimPrefix(filepath.ToSlash(path), "/")
+}
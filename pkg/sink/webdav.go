@@ -0,0 +1,89 @@
+package sink
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"twitterDownload/pkg/config"
+)
+
+// WebDAV uploads files below a collection of a WebDAV server, creating the
+// collections on the way
+type WebDAV struct {
+	Config config.WebDAVConfig
+	// Root is the output folder, files are uploaded to their path relative
+	// to it below Config.URL
+	Root   string
+	Client *http.Client
+
+	// created remembers collections known to exist
+	created sync.Map
+}
+
+// Put uploads body to the URL for path and returns that URL
+func (w *WebDAV) Put(ctx context.Context, path string, body io.Reader, size int64) (string, error) {
+	base := strings.TrimSuffix(w.Config.URL, "/")
+	key := relativeKey(w.Root, path)
+	segments := strings.Split(key, "/")
+	for i := range segments {
+		segments[i] = uriEncode(segments[i])
+	}
+
+	collection := base
+	for _, segment := range segments[:len(segments)-1] {
+		collection += "/" + segment
+		if err := w.mkcol(ctx, collection+"/"); err != nil {
+			return "", err
+		}
+	}
+
+	fileUrl := base + "/" + strings.Join(segments, "/")
+	resp, err := w.do(ctx, http.MethodPut, fileUrl, body, size)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated, http.StatusNoContent:
+		return fileUrl, nil
+	}
+	return "", fmt.Errorf("put %s: %s", fileUrl, resp.Status)
+}
+
+// mkcol creates a collection unless it is known to exist. 405 means it
+// exists already.
+func (w *WebDAV) mkcol(ctx context.Context, collectionUrl string) error {
+	if _, ok := w.created.Load(collectionUrl); ok {
+		return nil
+	}
+	resp, err := w.do(ctx, "MKCOL", collectionUrl, nil, 0)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusCreated, http.StatusMethodNotAllowed, http.StatusOK:
+		w.created.Store(collectionUrl, true)
+		return nil
+	}
+	return fmt.Errorf("mkcol %s: %s", collectionUrl, resp.Status)
+}
+
+func (w *WebDAV) do(ctx context.Context, method string, url string, body io.Reader, size int64) (*http.Response, error) {
+	if body == nil || size == 0 {
+		body = http.NoBody
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = size
+	if w.Config.Username != "" {
+		req.SetBasicAuth(w.Config.Username, w.Config.Password)
+	}
+	return w.Client.Do(req)
+}
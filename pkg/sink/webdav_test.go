@@ -0,0 +1,107 @@
+package sink
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"twitterDownload/pkg/config"
+)
+
+// davServer is a WebDAV server keeping collections and files in memory. It
+// refuses a PUT or MKCOL whose parent collection does not exist, like real
+// servers do.
+type davServer struct {
+	mu          sync.Mutex
+	requests    []string
+	collections map[string]bool
+	files       map[string]string
+}
+
+func (d *davServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	path := r.URL.EscapedPath()
+	d.requests = append(d.requests, r.Method+" "+path)
+	if user, password, ok := r.BasicAuth(); !ok || user != "me" || password != "pw" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	parent := path[:strings.LastIndex(strings.TrimSuffix(path, "/"), "/")+1]
+	if !d.collections[parent] {
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+	switch r.Method {
+	case "MKCOL":
+		if d.collections[path] {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		d.collections[path] = true
+		w.WriteHeader(http.StatusCreated)
+	case http.MethodPut:
+		body, _ := io.ReadAll(r.Body)
+		d.files[path] = string(body)
+		w.WriteHeader(http.StatusCreated)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func TestWebDAVPut(t *testing.T) {
+	dav := &davServer{collections: map[string]bool{"/": true, "/dav/": true, "/dav/123/": true}, files: map[string]string{}}
+	srv := httptest.NewServer(dav)
+	defer srv.Close()
+
+	root := t.TempDir()
+	w := &WebDAV{Config: config.WebDAVConfig{URL: srv.URL + "/dav/", Username: "me", Password: "pw"}, Root: root, Client: srv.Client()}
+	for _, name := range []string{"likes/a b.jpg", "likes/c.mp4", "record.csv"} {
+		location, err := w.Put(context.Background(), filepath.Join(root, "123", filepath.FromSlash(name)), strings.NewReader(name), int64(len(name)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := srv.URL + "/dav/123/" + strings.ReplaceAll(name, " ", "%20"); location != want {
+			t.Errorf("location %q, want %q", location, want)
+		}
+	}
+
+	// collections come before the files in them, each created once and
+	// the existing one answered with 405
+	want := []string{
+		"MKCOL /dav/123/",
+		"MKCOL /dav/123/likes/",
+		"PUT /dav/123/likes/a%20b.jpg",
+		"PUT /dav/123/likes/c.mp4",
+		"PUT /dav/123/record.csv",
+	}
+	if strings.Join(dav.requests, "\n") != strings.Join(want, "\n") {
+		t.Errorf("requests\n%s\nwant\n%s", strings.Join(dav.requests, "\n"), strings.Join(want, "\n"))
+	}
+	if got := dav.files["/dav/123/likes/c.mp4"]; got != "likes/c.mp4" {
+		t.Errorf("c.mp4 holds %q", got)
+	}
+}
+
+func TestWebDAVPutErrors(t *testing.T) {
+	dav := &davServer{collections: map[string]bool{"/": true}, files: map[string]string{}}
+	srv := httptest.NewServer(dav)
+	defer srv.Close()
+	root := t.TempDir()
+
+	// the base collection is missing
+	w := &WebDAV{Config: config.WebDAVConfig{URL: srv.URL + "/dav", Username: "me", Password: "pw"}, Root: root, Client: srv.Client()}
+	if _, err := w.Put(context.Background(), filepath.Join(root, "123", "a.jpg"), strings.NewReader("a"), 1); err == nil || !strings.Contains(err.Error(), "mkcol") {
+		t.Errorf("got %v, want the failed MKCOL", err)
+	}
+
+	w = &WebDAV{Config: config.WebDAVConfig{URL: srv.URL}, Root: root, Client: srv.Client()}
+	if _, err := w.Put(context.Background(), filepath.Join(root, "a.jpg"), strings.NewReader("a"), 1); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("got %v, want the refused PUT", err)
+	}
+}
@@ -3,42 +3,101 @@ package storage
 import (
 	"encoding/json"
 	"fmt"
+	"log/slog"
 	"os"
+	"path/filepath"
+	"sync"
 )
 
 // URLStorage defines the interface for URL storage operations
 type URLStorage interface {
 	AddURL(url string)
+	// AddLocation records url together with where its file was stored, a
+	// local path or the key of a remote sink
+	AddLocation(url string, location string)
+	// Location is where the file of url was stored, empty when unknown
+	Location(url string) string
+	// Entries returns a copy of every URL with its location
+	Entries() map[string]string
 	URLExists(url string) bool
 	RemoveURL(url string) error
 	SaveToFile() error
 	LoadFromFile() error
 }
 
-// URLStore holds the downloaded URLs
+// URLStore holds the downloaded URLs and where their files went. It is safe
+// for concurrent use.
+//
+// The file maps each URL to its location, or to true for entries written
+// before locations were recorded.
 type URLStore struct {
 	URLStoreFilePath string
-	URLs map[string]bool
+	URLs map[string]string
+	mu sync.RWMutex
 }
 
-// NewURLStore creates a new URLStore
+// NewURLStore creates a new URLStore. With an empty file name the store
+// only lives in memory and SaveToFile does nothing.
 func NewURLStore(storeFileName string) *URLStore {
-	return &URLStore{ URLStoreFilePath: storeFileName, URLs: make(map[string]bool)}
+	return &URLStore{ URLStoreFilePath: storeFileName, URLs: make(map[string]string)}
 }
 
-// AddURL adds a URL to the store
+// OpenURLStore creates a URLStore backed by path and loads it when the file
+// exists
+func OpenURLStore(path string) (*URLStore, error) {
+	s := NewURLStore(path)
+	if err := s.LoadFromFile(); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("load %s: %w", path, err)
+	}
+	return s, nil
+}
+
+// AddURL adds a URL to the store without a location
 func (s *URLStore) AddURL(url string) {
-	s.URLs[url] = true
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, exists := s.URLs[url]; !exists {
+		s.URLs[url] = ""
+	}
+}
+
+// AddLocation adds a URL to the store together with where it was saved
+func (s *URLStore) AddLocation(url string, location string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.URLs[url] = location
+}
+
+// Location returns where the file of a URL was saved
+func (s *URLStore) Location(url string) string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.URLs[url]
 }
 
 // URLExists checks if a URL exists in the store
 func (s *URLStore) URLExists(url string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	_, exists := s.URLs[url]
 	return exists
 }
 
+// Entries returns a copy of the stored URLs and their locations
+func (s *URLStore) Entries() map[string]string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	entries := make(map[string]string, len(s.URLs))
+	for url, location := range s.URLs {
+		entries[url] = location
+	}
+	return entries
+}
+
 // RemoveURL removes a URL from the store
 func (s *URLStore) RemoveURL(url string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	if _, exists := s.URLs[url]; !exists {
 		return fmt.Errorf("URL not found: %s", url)
 	}
@@ -46,13 +105,53 @@ func (s *URLStore) RemoveURL(url string) error {
 	return nil
 }
 
-// SaveToFile saves the URL store to a file
+// SaveToFile saves the URL store to a file, replacing it in one step
 func (s *URLStore) SaveToFile() error {
-	data, err := json.Marshal(s.URLs)
+	if s.URLStoreFilePath == "" {
+		return nil
+	}
+	s.mu.RLock()
+	entries := make(map[string]interface{}, len(s.URLs))
+	for url, location := range s.URLs {
+		if location == "" {
+			entries[url] = true
+		} else {
+			entries[url] = location
+		}
+	}
+	s.mu.RUnlock()
+	data, err := json.Marshal(entries)
 	if err != nil {
 		return err
 	}
-	return os.WriteFile(s.URLStoreFilePath, data, 0644)
+	slog.Debug("saving url store", "path", s.URLStoreFilePath, "urls", len(s.URLs))
+	return writeFileAtomic(s.URLStoreFilePath, data)
+}
+
+// writeFileAtomic writes data to a hidden temporary file next to path and
+// renames it over path, so a crash while writing leaves the old file intact
+func writeFileAtomic(path string, data []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if err == nil {
+		err = f.Chmod(0644)
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
 }
 
 // LoadFromFile loads the URL store from a file
@@ -61,5 +160,16 @@ func (s *URLStore) LoadFromFile() error {
 	if err != nil {
 		return err
 	}
-	return json.Unmarshal(data, &s.URLs)
+	var entries map[string]interface{}
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for url, value := range entries {
+		location, _ := value.(string)
+		s.URLs[url] = location
+	}
+	slog.Debug("loaded url store", "path", s.URLStoreFilePath, "urls", len(s.URLs))
+	return nil
 }
@@ -0,0 +1,69 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestURLStoreSaveAndLoad(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "log.json")
+	// entries of a ledger from before locations were recorded
+	if err := os.WriteFile(path, []byte(`{"https://pbs.twimg.com/media/old.jpg": true}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s, err := OpenURLStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.AddLocation("https://pbs.twimg.com/media/new.jpg", "123.zip!new.jpg")
+	if err := s.SaveToFile(); err != nil {
+		t.Fatal(err)
+	}
+
+	names, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 1 {
+		t.Errorf("%d files after saving, want only the ledger", len(names))
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0644 {
+		t.Errorf("ledger mode %v, %v", info.Mode(), err)
+	}
+
+	loaded, err := OpenURLStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !loaded.URLExists("https://pbs.twimg.com/media/old.jpg") || loaded.Location("https://pbs.twimg.com/media/old.jpg") != "" {
+		t.Error("entry without a location lost")
+	}
+	if got := loaded.Location("https://pbs.twimg.com/media/new.jpg"); got != "123.zip!new.jpg" {
+		t.Errorf("location %q", got)
+	}
+}
+
+func TestURLStoreSaveCleansUpOnFailure(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "log.json")
+	if err := os.WriteFile(path, []byte(`{"a": true}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s := NewURLStore(path)
+	s.AddURL("b")
+	// the rename fails when the ledger's path is a folder by then
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(path, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SaveToFile(); err == nil {
+		t.Fatal("saved over a folder")
+	}
+	if names, _ := os.ReadDir(dir); len(names) != 1 {
+		t.Errorf("temporary file left behind: %d entries", len(names))
+	}
+}
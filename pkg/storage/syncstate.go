@@ -0,0 +1,84 @@
+package storage
+
+import (
+	"encoding/json"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SyncState holds how far the media timeline of one user has been crawled
+type SyncState struct {
+	// HighWaterTweetId is the newest tweet ID seen by a crawl that finished,
+	// or lower when media failed to download, see AdvanceHighWater
+	HighWaterTweetId string `json:"highWaterTweetId,omitempty"`
+	// OldestCursor is the bottom cursor of the deepest page reached, where a
+	// backfill resumes
+	OldestCursor string `json:"oldestCursor,omitempty"`
+	// Complete is set once a crawl reached the end of the timeline
+	Complete  bool      `json:"complete"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// LoadSyncState reads a sync state file, returning an empty state when the
+// file does not exist yet
+func LoadSyncState(path string) (SyncState, error) {
+	var state SyncState
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return state, err
+	}
+	err = json.Unmarshal(data, &state)
+	return state, err
+}
+
+// SaveSyncState writes state to path
+func SaveSyncState(path string, state SyncState) error {
+	state.UpdatedAt = time.Now().UTC()
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// AdvanceHighWater moves HighWaterTweetId up to newest, the newest tweet of
+// a crawl that ran to its stop condition. The mark is kept below the oldest
+// of the failed tweets, whose media could not be saved, because the next
+// incremental run stops at the mark and would not reach them again. An
+// empty failed ID stands for a failure of unknown tweet and keeps the mark
+// where it is.
+func (s *SyncState) AdvanceHighWater(newest string, failed []string) {
+	mark := s.HighWaterTweetId
+	if CompareTweetIds(newest, mark) > 0 {
+		mark = newest
+	}
+	for _, id := range failed {
+		if CompareTweetIds(id, mark) > 0 {
+			continue
+		}
+		n, err := strconv.ParseUint(id, 10, 64)
+		if err != nil || n == 0 {
+			return
+		}
+		mark = strconv.FormatUint(n-1, 10)
+	}
+	s.HighWaterTweetId = mark
+}
+
+// CompareTweetIds orders two numeric tweet IDs without parsing them,
+// returning -1, 0 or 1. An empty ID sorts before any other.
+func CompareTweetIds(a string, b string) int {
+	a, b = strings.TrimLeft(a, "0"), strings.TrimLeft(b, "0")
+	switch {
+	case len(a) < len(b):
+		return -1
+	case len(a) > len(b):
+		return 1
+	}
+	return strings.Compare(a, b)
+}
@@ -0,0 +1,65 @@
+package storage
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestAdvanceHighWater(t *testing.T) {
+	tests := []struct {
+		name   string
+		mark   string
+		newest string
+		failed []string
+		want   string
+	}{
+		{"first crawl", "", "1790000000000000500", nil, "1790000000000000500"},
+		{"newer tweets", "1790000000000000100", "1790000000000000500", nil, "1790000000000000500"},
+		{"nothing newer", "1790000000000000500", "1790000000000000100", nil, "1790000000000000500"},
+		{"failed on a deep page", "1790000000000000100", "1790000000000000500", []string{"1790000000000000300", "1790000000000000200"}, "1790000000000000199"},
+		{"failed below the old mark", "1790000000000000100", "1790000000000000500", []string{"1790000000000000050"}, "1790000000000000049"},
+		{"failed tweet unknown", "1790000000000000100", "1790000000000000500", []string{""}, "1790000000000000100"},
+	}
+	for _, tt := range tests {
+		state := SyncState{HighWaterTweetId: tt.mark}
+		state.AdvanceHighWater(tt.newest, tt.failed)
+		if state.HighWaterTweetId != tt.want {
+			t.Errorf("%s: mark %q, want %q", tt.name, state.HighWaterTweetId, tt.want)
+		}
+	}
+}
+
+func TestSyncStateRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sync.json")
+	if state, err := LoadSyncState(path); err != nil || state.HighWaterTweetId != "" {
+		t.Fatalf("missing file: %+v, %v", state, err)
+	}
+	want := SyncState{HighWaterTweetId: "1790000000000000199", OldestCursor: "DAABCgAB", Complete: true}
+	if err := SaveSyncState(path, want); err != nil {
+		t.Fatal(err)
+	}
+	got, err := LoadSyncState(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.HighWaterTweetId != want.HighWaterTweetId || got.OldestCursor != want.OldestCursor || !got.Complete || got.UpdatedAt.IsZero() {
+		t.Errorf("read back %+v", got)
+	}
+}
+
+func TestCompareTweetIds(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"9", "10", -1},
+		{"1790000000000000500", "1790000000000000499", 1},
+		{"0012", "12", 0},
+		{"", "1", -1},
+	}
+	for _, tt := range tests {
+		if got := CompareTweetIds(tt.a, tt.b); got != tt.want {
+			t.Errorf("CompareTweetIds(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
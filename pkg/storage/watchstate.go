@@ -0,0 +1,46 @@
+package storage
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+)
+
+// WatchState records when watch mode last polled each user and when it is
+// due again, so a restarted watcher keeps its schedule
+type WatchState struct {
+	LastPoll map[string]time.Time `json:"lastPoll"`
+	NextPoll map[string]time.Time `json:"nextPoll"`
+}
+
+// LoadWatchState reads a watch state file, returning an empty state when the
+// file does not exist yet
+func LoadWatchState(path string) (WatchState, error) {
+	state := WatchState{LastPoll: map[string]time.Time{}, NextPoll: map[string]time.Time{}}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return state, err
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return state, err
+	}
+	if state.LastPoll == nil {
+		state.LastPoll = map[string]time.Time{}
+	}
+	if state.NextPoll == nil {
+		state.NextPoll = map[string]time.Time{}
+	}
+	return state, nil
+}
+
+// SaveWatchState writes state to path
+func SaveWatchState(path string, state WatchState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
@@ -0,0 +1,84 @@
+// Package thumb makes small JPEG previews of images using only the
+// standard library decoders.
+package thumb
+
+import (
+	"bytes"
+	"image"
+	"image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+)
+
+// DefaultSize is the longest edge of a thumbnail in pixels
+const DefaultSize = 320
+
+// Quality is the JPEG quality thumbnails are encoded with
+const Quality = 80
+
+// Make decodes a JPEG, PNG or GIF image and returns it as a JPEG whose
+// longest edge is at most size pixels. Smaller images keep their size.
+// Transparent pixels are laid on white. Other formats fail with
+// image.ErrFormat.
+func Make(data []byte, size int) ([]byte, error) {
+	if size <= 0 {
+		size = DefaultSize
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, Resize(img, size), &jpeg.Options{Quality: Quality}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Resize scales img down to fit in a size×size square, averaging the
+// source pixels that fall into each target pixel, and returns an opaque
+// image
+func Resize(img image.Image, size int) *image.RGBA {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	tw, th := w, h
+	if w > size || h > size {
+		if w >= h {
+			tw, th = size, max(h*size/w, 1)
+		} else {
+			tw, th = max(w*size/h, 1), size
+		}
+	}
+
+	src := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(src, src.Bounds(), img, b.Min, draw.Src)
+	dst := image.NewRGBA(image.Rect(0, 0, tw, th))
+	for y := 0; y < th; y++ {
+		y0, y1 := y*h/th, max((y+1)*h/th, y*h/th+1)
+		for x := 0; x < tw; x++ {
+			x0, x1 := x*w/tw, max((x+1)*w/tw, x*w/tw+1)
+			var r, g, bl, a, n int
+			for sy := y0; sy < y1; sy++ {
+				row := src.Pix[sy*src.Stride:]
+				for sx := x0; sx < x1; sx++ {
+					p := row[sx*4 : sx*4+4]
+					r += int(p[0])
+					g += int(p[1])
+					bl += int(p[2])
+					a += int(p[3])
+					n++
+				}
+			}
+			// the pixels are alpha premultiplied, so adding the missing
+			// coverage puts them on a white background
+			white := 255 - a/n
+			i := y*dst.Stride + x*4
+			dst.Pix[i] = uint8(r/n + white)
+			dst.Pix[i+1] = uint8(g/n + white)
+			dst.Pix[i+2] = uint8(bl/n + white)
+			dst.Pix[i+3] = 255
+		}
+	}
+	return dst
+}
@@ -0,0 +1,73 @@
+package thumb
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+func TestResize(t *testing.T) {
+	tests := []struct {
+		w, h   int
+		tw, th int
+	}{
+		{1000, 500, 320, 160},
+		{300, 900, 106, 320},
+		{640, 640, 320, 320},
+		{1, 2000, 1, 320},
+		// smaller images keep their size
+		{100, 50, 100, 50},
+		{320, 10, 320, 10},
+	}
+	for _, tt := range tests {
+		got := Resize(image.NewRGBA(image.Rect(0, 0, tt.w, tt.h)), DefaultSize).Bounds()
+		if got.Dx() != tt.tw || got.Dy() != tt.th {
+			t.Errorf("%dx%d resized to %dx%d, want %dx%d", tt.w, tt.h, got.Dx(), got.Dy(), tt.tw, tt.th)
+		}
+	}
+}
+
+func TestResizeAverages(t *testing.T) {
+	// columns alternate black and white, so each target pixel is grey
+	src := image.NewRGBA(image.Rect(0, 0, 4, 2))
+	for x := 0; x < 4; x++ {
+		for y := 0; y < 2; y++ {
+			src.Set(x, y, color.Gray{Y: uint8(255 * (x % 2))})
+		}
+	}
+	dst := Resize(src, 2)
+	if got := dst.RGBAAt(1, 0); got != (color.RGBA{127, 127, 127, 255}) {
+		t.Errorf("pixel %v, want grey", got)
+	}
+}
+
+func TestMake(t *testing.T) {
+	// a transparent PNG ends up on white
+	src := image.NewNRGBA(image.Rect(0, 0, 800, 600))
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, src); err != nil {
+		t.Fatal(err)
+	}
+	data, err := Make(buf.Bytes(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("not a JPEG: %v", err)
+	}
+	if b := img.Bounds(); b.Dx() != 320 || b.Dy() != 240 {
+		t.Errorf("thumbnail of %dx%d, want 320x240", b.Dx(), b.Dy())
+	}
+	if r, g, b, _ := img.At(100, 100).RGBA(); r>>8 < 250 || g>>8 < 250 || b>>8 < 250 {
+		t.Errorf("transparent pixel became %d,%d,%d, want white", r>>8, g>>8, b>>8)
+	}
+
+	if _, err := Make([]byte("RIFF\x00\x00\x00\x00WEBPVP8 "), 0); !errors.Is(err, image.ErrFormat) {
+		t.Errorf("WebP: got %v, want %v", err, image.ErrFormat)
+	}
+}
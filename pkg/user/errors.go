@@ -0,0 +1,76 @@
+package user
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/tidwall/gjson"
+)
+
+var (
+	ErrUserNotFound = errors.New("user not found")
+	ErrSuspended    = errors.New("user suspended")
+	ErrProtected    = errors.New("user is protected")
+	ErrUnavailable  = errors.New("user unavailable")
+)
+
+// RequestError is a GraphQL error that says nothing about the account, such
+// as a rate limit or a refused session. The same request may succeed later.
+type RequestError struct {
+	Code    int64
+	Message string
+}
+
+func (e *RequestError) Error() string {
+	return fmt.Sprintf("request failed with code %d: %s", e.Code, e.Message)
+}
+
+// GraphQL error codes returned for missing and suspended accounts
+const (
+	codeUserNotFound  = 50
+	codeUserSuspended = 63
+)
+
+// parseUserResponse maps a UserByScreenName/UserByRestId response to a
+// UserInfo, or to one of the typed errors when the account cannot be crawled.
+// Only a UserUnavailable result means the account itself is unavailable; an
+// unknown error code is returned as a *RequestError.
+func parseUserResponse(body []byte) (UserInfo, error) {
+	result := gjson.GetBytes(body, "data.user.result")
+
+	if !result.Exists() {
+		for _, e := range gjson.GetBytes(body, "errors").Array() {
+			message := e.Get("message").String()
+			switch e.Get("code").Int() {
+			case codeUserNotFound:
+				return UserInfo{}, fmt.Errorf("%w: %s", ErrUserNotFound, message)
+			case codeUserSuspended:
+				return UserInfo{}, fmt.Errorf("%w: %s", ErrSuspended, message)
+			default:
+				return UserInfo{}, &RequestError{Code: e.Get("code").Int(), Message: message}
+			}
+		}
+		return UserInfo{}, ErrUserNotFound
+	}
+
+	if result.Get("__typename").String() == "UserUnavailable" {
+		reason := result.Get("reason").String()
+		if reason == "Suspended" {
+			return UserInfo{}, ErrSuspended
+		}
+		if reason == "" {
+			reason = result.Get("unavailable_message.text").String()
+		}
+		return UserInfo{}, fmt.Errorf("%w: %s", ErrUnavailable, reason)
+	}
+
+	userInfo := ParseUserResult(result)
+	if userInfo.UserId == "" {
+		return UserInfo{}, ErrUserNotFound
+	}
+	// protected accounts are only readable when the cookie's account follows them
+	if userInfo.Protected && !result.Get("legacy.following").Bool() {
+		return userInfo, ErrProtected
+	}
+	return userInfo, nil
+}
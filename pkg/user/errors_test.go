@@ -0,0 +1,41 @@
+package user
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseUserResponse(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want error
+	}{
+		{"not found", `{"errors":[{"code":50,"message":"User not found."}]}`, ErrUserNotFound},
+		{"suspended code", `{"errors":[{"code":63,"message":"User has been suspended."}]}`, ErrSuspended},
+		{"suspended result", `{"data":{"user":{"result":{"__typename":"UserUnavailable","reason":"Suspended"}}}}`, ErrSuspended},
+		{"unavailable", `{"data":{"user":{"result":{"__typename":"UserUnavailable","unavailable_message":{"text":"This account doesn't exist"}}}}}`, ErrUnavailable},
+		{"empty", `{"data":{}}`, ErrUserNotFound},
+		{"protected", `{"data":{"user":{"result":{"__typename":"User","rest_id":"1","legacy":{"screen_name":"a","protected":true}}}}}`, ErrProtected},
+	}
+	for _, tt := range tests {
+		if _, err := parseUserResponse([]byte(tt.body)); !errors.Is(err, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.want)
+		}
+	}
+
+	// a rate limit is a failed request, not an unavailable account
+	_, err := parseUserResponse([]byte(`{"errors":[{"code":88,"message":"Rate limit exceeded"}]}`))
+	var requestErr *RequestError
+	if !errors.As(err, &requestErr) || requestErr.Code != 88 || requestErr.Message != "Rate limit exceeded" {
+		t.Errorf("got %v, want a request error with code 88", err)
+	}
+	if errors.Is(err, ErrUnavailable) {
+		t.Error("rate limit reported as an unavailable account")
+	}
+
+	info, err := parseUserResponse([]byte(`{"data":{"user":{"result":{"__typename":"User","rest_id":"1","legacy":{"screen_name":"a","protected":true,"following":true}}}}}`))
+	if err != nil || info.UserId != "1" {
+		t.Errorf("followed protected account: %+v, %v", info, err)
+	}
+}
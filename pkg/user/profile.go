@@ -0,0 +1,143 @@
+package user
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ProfileSnapshot is the state of a user's profile at one point in time.
+// The counts are only kept in the latest snapshot, see History.
+type ProfileSnapshot struct {
+	FetchedAt      time.Time `json:"fetchedAt"`
+	UserId         string    `json:"userId"`
+	UserName       string    `json:"userName"`
+	DisplayName    string    `json:"displayName"`
+	Description    string    `json:"description"`
+	Location       string    `json:"location"`
+	URL            string    `json:"url"`
+	FollowersCount int       `json:"followersCount,omitempty"`
+	FollowingCount int       `json:"followingCount,omitempty"`
+	MediaCount     int       `json:"mediaCount,omitempty"`
+	StatusesCount  int       `json:"statusesCount,omitempty"`
+	Verified       bool      `json:"verified"`
+	Protected      bool      `json:"protected"`
+	PinnedTweetId  string    `json:"pinnedTweetId,omitempty"`
+	AvatarURL      string    `json:"avatarUrl"`
+	BannerURL      string    `json:"bannerUrl"`
+	AvatarFile     string    `json:"avatarFile,omitempty"`
+	BannerFile     string    `json:"bannerFile,omitempty"`
+}
+
+// ProfileDirName is the folder under a user's save dir holding profile data
+const ProfileDirName = "profile"
+
+const (
+	latestSnapshotName = "profile.json"
+	snapshotTimeLayout = "20060102-150405"
+)
+
+// NewProfileSnapshot captures the profile fields of userInfo
+func NewProfileSnapshot(userInfo *UserInfo, fetchedAt time.Time) ProfileSnapshot {
+	return ProfileSnapshot{
+		FetchedAt:      fetchedAt.UTC(),
+		UserId:         userInfo.UserId,
+		UserName:       userInfo.UserName,
+		DisplayName:    userInfo.DisplayName,
+		Description:    userInfo.Description,
+		Location:       userInfo.Location,
+		URL:            userInfo.URL,
+		FollowersCount: userInfo.FollowersCount,
+		FollowingCount: userInfo.FollowingCount,
+		MediaCount:     userInfo.MediaCount,
+		StatusesCount:  userInfo.StatusesCount,
+		Verified:       userInfo.Verified,
+		Protected:      userInfo.Protected,
+		PinnedTweetId:  userInfo.PinnedTweetId,
+		AvatarURL:      userInfo.AvatarURL,
+		BannerURL:      userInfo.BannerURL,
+	}
+}
+
+// SameProfile reports whether two snapshots hold the same profile fields,
+// ignoring when they were fetched, where the images were saved and the
+// counts
+func (s ProfileSnapshot) SameProfile(other ProfileSnapshot) bool {
+	s, other = s.History(), other.History()
+	s.FetchedAt, other.FetchedAt = time.Time{}, time.Time{}
+	s.AvatarFile, other.AvatarFile = "", ""
+	s.BannerFile, other.BannerFile = "", ""
+	return s == other
+}
+
+// History is the copy of s kept in the profile history. Follower,
+// following, media and tweet counts change on almost every run, so they
+// are left out and no new history copy is written for them.
+func (s ProfileSnapshot) History() ProfileSnapshot {
+	s.FollowersCount, s.FollowingCount, s.MediaCount, s.StatusesCount = 0, 0, 0, 0
+	return s
+}
+
+// Stamp is the timestamp used in snapshot and image file names
+func (s ProfileSnapshot) Stamp() string {
+	return s.FetchedAt.Format(snapshotTimeLayout)
+}
+
+// FullSizeAvatarURL strips the _normal/_bigger/_mini size suffix from a
+// profile_image_url_https value
+func FullSizeAvatarURL(avatarURL string) string {
+	for _, size := range []string{"_normal", "_bigger", "_mini", "_200x200", "_400x400"} {
+		ext := filepath.Ext(avatarURL)
+		if strings.HasSuffix(strings.TrimSuffix(avatarURL, ext), size) {
+			return strings.TrimSuffix(avatarURL, size+ext) + ext
+		}
+	}
+	return avatarURL
+}
+
+// FullSizeBannerURL returns the largest rendition of a profile_banner_url value
+func FullSizeBannerURL(bannerURL string) string {
+	if bannerURL == "" {
+		return ""
+	}
+	return strings.TrimSuffix(bannerURL, "/") + "/1500x500"
+}
+
+// LoadLatestSnapshot reads profile/profile.json under dir. The bool is false
+// when no snapshot has been saved yet.
+func LoadLatestSnapshot(dir string) (ProfileSnapshot, bool, error) {
+	var snapshot ProfileSnapshot
+	data, err := os.ReadFile(filepath.Join(dir, ProfileDirName, latestSnapshotName))
+	if os.IsNotExist(err) {
+		return snapshot, false, nil
+	}
+	if err != nil {
+		return snapshot, false, err
+	}
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return snapshot, false, err
+	}
+	return snapshot, true, nil
+}
+
+// HistoryName is the file name of the timestamped copy of a snapshot kept
+// whenever the profile changed
+func (s ProfileSnapshot) HistoryName() string {
+	return "profile-" + s.Stamp() + ".json"
+}
+
+// SaveSnapshot writes snapshot as profile/profile.json under dir, where the
+// next run compares against it
+func SaveSnapshot(dir string, snapshot ProfileSnapshot) error {
+	profileDir := filepath.Join(dir, ProfileDirName)
+	if err := os.MkdirAll(profileDir, 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(profileDir, latestSnapshotName), data, 0644)
+}
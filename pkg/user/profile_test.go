@@ -0,0 +1,47 @@
+package user
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSameProfile(t *testing.T) {
+	base := ProfileSnapshot{
+		FetchedAt: time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC), UserId: "123", UserName: "someone", DisplayName: "Some One",
+		FollowersCount: 100, FollowingCount: 10, MediaCount: 5, StatusesCount: 50,
+		AvatarURL: "https://pbs.twimg.com/profile_images/1/a.jpg", AvatarFile: "avatar-20240501-000000.jpg",
+	}
+	later := base
+	later.FetchedAt = base.FetchedAt.Add(time.Hour)
+	later.FollowersCount, later.FollowingCount, later.MediaCount, later.StatusesCount = 101, 11, 6, 52
+	later.AvatarFile = ""
+	if !base.SameProfile(later) {
+		t.Error("changed counts make a new profile")
+	}
+	renamed := later
+	renamed.DisplayName = "Someone Else"
+	if base.SameProfile(renamed) {
+		t.Error("changed display name not noticed")
+	}
+	avatar := later
+	avatar.AvatarURL = "https://pbs.twimg.com/profile_images/2/b.jpg"
+	if base.SameProfile(avatar) {
+		t.Error("changed avatar not noticed")
+	}
+}
+
+func TestHistoryLeavesOutCounts(t *testing.T) {
+	snapshot := ProfileSnapshot{UserId: "123", FollowersCount: 100, StatusesCount: 50}
+	data, err := json.Marshal(snapshot.History())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "Count") {
+		t.Errorf("history copy %s has counts", data)
+	}
+	if snapshot.FollowersCount != 100 {
+		t.Error("History changed the snapshot")
+	}
+}
@@ -1,9 +1,15 @@
 package user
 
 import (
+	"context"
 	"encoding/json"
-	"log"
+	"fmt"
+	"log/slog"
 	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"twitterDownload/pkg/collector"
 
@@ -17,12 +23,22 @@ type UserInfo struct {
 	DisplayName    string
 	FollowersCount int
 	FollowingCount int
-	TweetCount     int
+	MediaCount     int
+	StatusesCount  int
+	CreatedAt      time.Time
+	Verified       bool
+	Protected      bool
+	PinnedTweetId  string
+	Description    string
+	Location       string
+	URL            string
+	AvatarURL      string
+	BannerURL      string
 	NextPageToken  string
 	SaveDir        string
 }
 
-func GenerateTwitterUserInfoUrl(name string) string {
+func GenerateTwitterUserInfoUrl(api collector.API, name string) string {
 	createVarParams := func(userName string) string {
 		variables := map[string]interface{}{
 			"screen_name":              userName,
@@ -30,7 +46,7 @@ func GenerateTwitterUserInfoUrl(name string) string {
 		}
 		variablesData, err := json.Marshal(variables)
 		if err != nil {
-			log.Println(err)
+			slog.Error("encode user request", "err", err)
 			return ""
 		}
 		return string(variablesData)
@@ -40,7 +56,7 @@ func GenerateTwitterUserInfoUrl(name string) string {
 		features := map[string]interface{}{"hidden_profile_likes_enabled": true, "hidden_profile_subscriptions_enabled": true, "rweb_tipjar_consumption_enabled": true, "responsive_web_graphql_exclude_directive_enabled": true, "verified_phone_label_enabled": false, "subscriptions_verification_info_is_identity_verified_enabled": true, "subscriptions_verification_info_verified_since_enabled": true, "highlights_tweets_tab_ui_enabled": true, "responsive_web_twitter_article_notes_tab_enabled": true, "creator_subscriptions_tweet_preview_api_enabled": true, "responsive_web_graphql_skip_user_profile_image_extensions_enabled": false, "responsive_web_graphql_timeline_navigation_enabled": true}
 		featuresData, err := json.Marshal(features)
 		if err != nil {
-			log.Println(err)
+			slog.Error("encode user request", "err", err)
 			return ""
 		}
 		return string(featuresData)
@@ -49,40 +65,143 @@ func GenerateTwitterUserInfoUrl(name string) string {
 	queryParams := url.Values{}
 	queryParams.Add("variables", createVarParams(name))
 	queryParams.Add("features", createFeatParams())
-	reqTwitterUserInfoUrl := &url.URL{
-		Scheme:   "https",
-		Host:     "twitter.com",
-		Path:     "/i/api/graphql/qW5u-DAuXpMEG0zA1F7UGQ/UserByScreenName",
-		RawQuery: queryParams.Encode(),
+	return api.URL("/i/api/graphql/qW5u-DAuXpMEG0zA1F7UGQ/UserByScreenName", queryParams)
+}
+
+func GenerateTwitterUserByRestIdUrl(api collector.API, userId string) string {
+	variables, err := json.Marshal(map[string]interface{}{
+		"userId":                   userId,
+		"withSafetyModeUserFields": true,
+	})
+	if err != nil {
+		slog.Error("encode user request", "err", err)
+		return ""
+	}
+	features, err := json.Marshal(map[string]interface{}{
+		"hidden_profile_likes_enabled":                                      true,
+		"hidden_profile_subscriptions_enabled":                              true,
+		"rweb_tipjar_consumption_enabled":                                   true,
+		"responsive_web_graphql_exclude_directive_enabled":                  true,
+		"verified_phone_label_enabled":                                      false,
+		"highlights_tweets_tab_ui_enabled":                                  true,
+		"responsive_web_twitter_article_notes_tab_enabled":                  true,
+		"creator_subscriptions_tweet_preview_api_enabled":                   true,
+		"responsive_web_graphql_skip_user_profile_image_extensions_enabled": false,
+		"responsive_web_graphql_timeline_navigation_enabled":                true,
+	})
+	if err != nil {
+		slog.Error("encode user request", "err", err)
+		return ""
+	}
+
+	queryParams := url.Values{}
+	queryParams.Add("variables", string(variables))
+	queryParams.Add("features", string(features))
+	return api.URL("/i/api/graphql/tD8zKvQzwY3kdx5yz6YmOw/UserByRestId", queryParams)
+}
+
+// ParseUserResult maps the data.user.result object shared by UserByScreenName
+// and UserByRestId
+func ParseUserResult(result gjson.Result) UserInfo {
+	legacy := result.Get("legacy")
+	createdAt, _ := time.Parse(time.RubyDate, legacy.Get("created_at").String())
+	return UserInfo{
+		UserId:         result.Get("rest_id").String(),
+		UserName:       legacy.Get("screen_name").String(),
+		DisplayName:    legacy.Get("name").String(),
+		FollowersCount: int(legacy.Get("followers_count").Int()),
+		FollowingCount: int(legacy.Get("friends_count").Int()),
+		MediaCount:     int(legacy.Get("media_count").Int()),
+		StatusesCount:  int(legacy.Get("statuses_count").Int()),
+		CreatedAt:      createdAt,
+		Verified:       legacy.Get("verified").Bool() || result.Get("is_blue_verified").Bool(),
+		Protected:      legacy.Get("protected").Bool(),
+		PinnedTweetId:  legacy.Get("pinned_tweet_ids_str.0").String(),
+		Description:    legacy.Get("description").String(),
+		Location:       legacy.Get("location").String(),
+		URL:            legacy.Get("entities.url.urls.0.expanded_url").String(),
+		AvatarURL:      legacy.Get("profile_image_url_https").String(),
+		BannerURL:      legacy.Get("profile_banner_url").String(),
 	}
-	return reqTwitterUserInfoUrl.String()
 }
 
-func FetchUserInfo(userName string) (UserInfo, error) {
-	c := collector.NewCollector()
+func fetchUser(ctx context.Context, api collector.API, reqUrl string) (UserInfo, error) {
+	c := collector.NewCollector(ctx, api)
 	var userInfo UserInfo
 	var err error
 
 	c.OnResponse(func(r *colly.Response) {
-			result := string(r.Body)
-			userInfo = UserInfo{
-					UserId:         gjson.Get(result, "data.user.result.rest_id").String(),
-					UserName:       gjson.Get(result, "data.user.result.legacy.screen_name").String(),
-					DisplayName:    gjson.Get(result, "data.user.result.legacy.name").String(),
-					FollowersCount: int(gjson.Get(result, "data.user.result.legacy.followers_count").Int()),
-					FollowingCount: int(gjson.Get(result, "data.user.result.legacy.friends_count").Int()),
-					TweetCount:     int(gjson.Get(result, "data.user.result.legacy.media_count").Int()),
-			}
+			userInfo, err = parseUserResponse(r.Body)
 	})
 
 	c.OnError(func(r *colly.Response, e error) {
-			log.Println("Request URL:", r.Request.URL, "failed with response:", r, "\nError:", e)
+			slog.Warn("user request failed", "url", r.Request.URL.String(), "status", r.StatusCode, "err", e)
 			err = e
 	})
 
-	c.Visit(GenerateTwitterUserInfoUrl(userName))
+	c.Visit(reqUrl)
 	c.Wait()
+	if ctxErr := ctx.Err(); ctxErr != nil && err == nil {
+		err = ctxErr
+	}
 
 	return userInfo, err
 }
 
+func FetchUserInfo(ctx context.Context, api collector.API, userName string) (UserInfo, error) {
+	return fetchUser(ctx, api, GenerateTwitterUserInfoUrl(api, userName))
+}
+
+// FetchUserInfoByID looks a user up by the numeric rest_id, which survives
+// screen name changes
+func FetchUserInfoByID(ctx context.Context, api collector.API, userId string) (UserInfo, error) {
+	return fetchUser(ctx, api, GenerateTwitterUserByRestIdUrl(api, userId))
+}
+
+// IDPrefix marks a userList entry or menu input as a numeric user ID,
+// e.g. "id:783214". Plain entries are screen names.
+const IDPrefix = "id:"
+
+// FetchUser resolves a user reference that is either a screen name or an
+// IDPrefix-ed user ID. Missing, suspended, protected and otherwise unavailable
+// accounts are reported as ErrUserNotFound, ErrSuspended, ErrProtected and
+// ErrUnavailable, wrapped with the reference; other API errors are a
+// *RequestError.
+func FetchUser(ctx context.Context, api collector.API, ref string) (UserInfo, error) {
+	var userInfo UserInfo
+	var err error
+	if userId, ok := strings.CutPrefix(ref, IDPrefix); ok {
+		userInfo, err = FetchUserInfoByID(ctx, api, strings.TrimSpace(userId))
+	} else {
+		userInfo, err = FetchUserInfo(ctx, api, strings.TrimPrefix(strings.TrimSpace(ref), "@"))
+	}
+	if err != nil {
+		return userInfo, fmt.Errorf("%s: %w", ref, err)
+	}
+	return userInfo, nil
+}
+
+// SaveDir is the folder of a user's archive under the output directory root,
+// named after the stable user ID and ending in a slash
+func SaveDir(userInfo UserInfo, root string) string {
+	return filepath.Join(root, userInfo.UserId) + "/"
+}
+
+// PrepareSaveDir points SaveDir at the folder named after the stable user ID.
+// Archives created before IDs were used live in a folder named after the
+// screen name; such a folder is renamed once so the archive carries over.
+// Both live under the output directory root.
+func PrepareSaveDir(userInfo *UserInfo, root string) error {
+	idDir := filepath.Join(root, userInfo.UserId)
+	userInfo.SaveDir = SaveDir(*userInfo, root)
+	if _, err := os.Stat(idDir); err == nil {
+		return nil
+	}
+	nameDir := filepath.Join(root, userInfo.UserName)
+	if info, err := os.Stat(nameDir); err == nil && info.IsDir() && userInfo.UserName != "" {
+		slog.Info("moving archive to user id folder", "from", nameDir, "to", idDir)
+		return os.Rename(nameDir, idDir)
+	}
+	return nil
+}
+
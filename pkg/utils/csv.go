@@ -2,6 +2,7 @@ package utils
 
 import (
 	"encoding/csv"
+	"io"
 	"os"
 )
 
@@ -30,7 +31,12 @@ func SaveToCSV(records []CSV, filePath string) error {
 	}
 	defer file.Close()
 
-	writer := csv.NewWriter(file)
+	return WriteCSV(file, records, writeHeaders)
+}
+
+// WriteCSV 把记录写入 w，writeHeaders 为真时先写表头
+func WriteCSV(w io.Writer, records []CSV, writeHeaders bool) error {
+	writer := csv.NewWriter(w)
 	defer writer.Flush()
 
 	// 如果需要，写入头部
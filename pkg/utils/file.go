@@ -1,44 +1,15 @@
 package utils
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
-	"errors"
+	"fmt"
 	"io"
-	"log"
 	"os"
-	"sync"
+	"strings"
 )
 
-var (
-	settingsCache map[string]interface{}
-	settingsMutex sync.Mutex
-)
-
-func ReadSettings() (map[string]interface{}, error) {
-	settingsMutex.Lock()
-	defer settingsMutex.Unlock()
-
-	if settingsCache != nil {
-		return settingsCache, nil
-	}
-
-	file, err := os.Open("setting.json")
-	if err != nil {
-		return nil, err
-	}
-	defer file.Close()
-
-	decoder := json.NewDecoder(file)
-	settings := make(map[string]interface{})
-	err = decoder.Decode(&settings)
-	if err != nil {
-		return nil, err
-	}
-
-	settingsCache = settings
-	return settings, nil
-}
-
 func SaveMediaFile(savePath string, saveFileName string, saveContent []byte) error {
 	fileName := saveFileName
 	dir := savePath
@@ -46,25 +17,52 @@ func SaveMediaFile(savePath string, saveFileName string, saveContent []byte) err
 	if _, err := os.Stat(dir); os.IsNotExist(err) {
 		err := os.MkdirAll(dir, 0755)
 		if err != nil {
-			log.Println("Error creating directory:", err)
-			return errors.New("error creating directory")
+			return fmt.Errorf("error creating directory: %w", err)
 		}
 	}
 	file, err := os.Create(dir + fileName)
 	if err != nil {
-		log.Println("Error creating file:", err)
-		return errors.New("error creating file")
+		return fmt.Errorf("error creating file: %w", err)
 	}
 	defer file.Close()
 
 	_, err = file.Write(saveContent)
 	if err != nil {
-		log.Println("Error saving file:", err)
-		return errors.New("error saving file")
+		return fmt.Errorf("error saving file: %w", err)
 	}
 	return nil
 }
 
+// HashFile 计算文件内容的 SHA-256，返回十六进制字符串
+func HashFile(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// ExpandNameTemplate 将模板中的 {字段} 替换为对应的值，并去掉文件名中不允许的字符
+func ExpandNameTemplate(template string, fields map[string]string) string {
+	pairs := make([]string, 0, len(fields)*2)
+	for key, value := range fields {
+		pairs = append(pairs, "{"+key+"}", value)
+	}
+	name := strings.NewReplacer(pairs...).Replace(template)
+	return strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`/\:*?"<>|`, r) {
+			return '_'
+		}
+		return r
+	}, name)
+}
+
 // LoadURLsFromJSON 从指定的JSON文件中读取URLs
 func LoadURLsFromJSON(filePath string) ([]string, error) {
 	// 打开文件
@@ -1,6 +1,10 @@
 package utils
 
 import (
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
 	"strings"
 )
 
@@ -61,14 +65,142 @@ func IsVideoUrl(url string) bool {
 	return false
 }
 
+// IsHLSUrl 判断给定的URL是否指向一个HLS播放列表
+func IsHLSUrl(url string) bool {
+	return strings.HasSuffix(strings.ToLower(url), ".m3u8")
+}
+
+// URLExt 返回URL指向的文件扩展名，优先使用 ?format=jpg 形式的查询参数
+func URLExt(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	if format := u.Query().Get("format"); format != "" {
+		return "." + strings.ToLower(format)
+	}
+	// 去掉 :orig、:large 之类的尺寸后缀
+	p, _, _ := strings.Cut(u.Path, ":")
+	return strings.ToLower(path.Ext(p))
+}
+
+// FileType 根据URL的扩展名判断媒体类型
 func FileType(url string) string {
-	if IsImageUrl(url) {
+	ext := URLExt(url)
+	if IsHLSUrl(ext) {
+		return "hls"
+	} else if IsImageUrl(ext) {
 		return "image"
-	} else if IsAudioUrl(url) {
+	} else if IsAudioUrl(ext) {
 		return "audio"
-	} else if IsVideoUrl(url) {
+	} else if IsVideoUrl(ext) {
 		return "video"
 	} else {
 		return "unknown"
 	}
 }
+
+// contentTypeExt 常见媒体Content-Type对应的文件扩展名
+var contentTypeExt = map[string]string{
+	"image/jpeg":                    ".jpg",
+	"image/png":                     ".png",
+	"image/gif":                     ".gif",
+	"image/webp":                    ".webp",
+	"image/bmp":                     ".bmp",
+	"video/mp4":                     ".mp4",
+	"video/quicktime":               ".mov",
+	"video/webm":                    ".webm",
+	"video/mp2t":                    ".ts",
+	"audio/mp4":                     ".m4a",
+	"audio/aac":                     ".aac",
+	"audio/mpeg":                    ".mp3",
+	"audio/wave":                    ".wav",
+	"audio/ogg":                     ".ogg",
+	"application/ogg":               ".ogg",
+	"application/x-mpegurl":         ".m3u8",
+	"application/vnd.apple.mpegurl": ".m3u8",
+}
+
+// KindFromContentType 根据Content-Type判断媒体类型
+func KindFromContentType(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return "unknown"
+	}
+	switch {
+	case mediaType == "application/x-mpegurl" || mediaType == "application/vnd.apple.mpegurl":
+		return "hls"
+	case mediaType == "application/ogg":
+		return "audio"
+	case strings.HasPrefix(mediaType, "image/"):
+		return "image"
+	case strings.HasPrefix(mediaType, "video/"):
+		return "video"
+	case strings.HasPrefix(mediaType, "audio/"):
+		return "audio"
+	default:
+		return "unknown"
+	}
+}
+
+// ExtFromContentType 返回Content-Type对应的文件扩展名，未知时返回空字符串
+func ExtFromContentType(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ""
+	}
+	return contentTypeExt[mediaType]
+}
+
+// ClassifyMedia 根据API返回的 media.type 和 variant.content_type 判断媒体类型，
+// 两者都无法判断时退回到URL扩展名
+func ClassifyMedia(mediaType string, contentType string, url string) string {
+	if kind := KindFromContentType(contentType); kind != "unknown" {
+		return kind
+	}
+	switch mediaType {
+	case "photo":
+		return "image"
+	case "video", "animated_gif":
+		if IsHLSUrl(URLExt(url)) {
+			return "hls"
+		}
+		return "video"
+	}
+	return FileType(url)
+}
+
+// DetectMedia 根据响应的Content-Type判断媒体类型和扩展名，
+// Content-Type缺失或过于笼统时通过文件头的魔数判断
+func DetectMedia(contentType string, data []byte) (kind string, ext string) {
+	kind, ext = KindFromContentType(contentType), ExtFromContentType(contentType)
+	if kind != "unknown" && ext != "" {
+		return kind, ext
+	}
+
+	sniffed := http.DetectContentType(data)
+	kind, ext = KindFromContentType(sniffed), ExtFromContentType(sniffed)
+	if kind != "unknown" && ext != "" {
+		return kind, ext
+	}
+
+	// http.DetectContentType 只识别品牌为 mp4* 的 ftyp，这里补充 isom、M4A 等
+	if len(data) >= 12 && string(data[4:8]) == "ftyp" {
+		if strings.HasPrefix(string(data[8:12]), "M4A") {
+			return "audio", ".m4a"
+		}
+		return "video", ".mp4"
+	}
+	if len(data) > 188 && data[0] == 0x47 && data[188] == 0x47 {
+		return "video", ".ts"
+	}
+	return "unknown", ""
+}
+
+// ReplaceExt 将文件名的扩展名替换为ext
+func ReplaceExt(fileName string, ext string) string {
+	if ext == "" {
+		return fileName
+	}
+	return strings.TrimSuffix(fileName, path.Ext(fileName)) + ext
+}
@@ -2,7 +2,9 @@ package utils
 
 import (
 	"encoding/json"
-	"log"
+	"log/slog"
+	"net/url"
+	"path"
 	"strings"
 	"time"
 )
@@ -19,13 +21,22 @@ type VideoInfo struct {
 	Variants       []Variant
 }
 
+// OriginalInfo 原始媒体的尺寸
+type OriginalInfo struct {
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
 // Media 结构体用于存储视频信息，包括一个额外的布尔值标识是否为视频
 type Media struct {
-	Type        string    `json:"type"`
-	ExpandedUrl string    `json:"expanded_url"`
-	MediaURL    string    `json:"media_url_https,omitempty"` // 使用omitempty标签，当字段为空时不输出到JSON
-	IsVideo     bool      `json:"-"`                         // 使用"-"忽略此字段的JSON序列化和反序列化
-	VideoInfo   VideoInfo `json:"video_info,omitempty"`
+	Type        string `json:"type"`
+	ExpandedUrl string `json:"expanded_url"`
+	// MediaKey 媒体的唯一标识，如 3_1789012345678901234
+	MediaKey     string       `json:"media_key"`
+	MediaURL     string       `json:"media_url_https,omitempty"` // 使用omitempty标签，当字段为空时不输出到JSON
+	IsVideo      bool         `json:"-"`                         // 使用"-"忽略此字段的JSON序列化和反序列化
+	OriginalInfo OriginalInfo `json:"original_info"`
+	VideoInfo    VideoInfo    `json:"video_info,omitempty"`
 }
 
 type Extended struct {
@@ -37,6 +48,8 @@ type Legacy struct {
 	Extended  Extended `json:"extended_entities"`
 	TweetID   string   `json:"id_str"`
 	TweetText string   `json:"full_text"`
+	// UserID 发推用户的ID，喜欢时间线上不一定是时间线的主人
+	UserID string `json:"user_id_str"`
 }
 
 // ExtractMedias 从JSON数组字符串中提取视频信息
@@ -66,19 +79,38 @@ func ExtractLegacyList(jsonArrayStr string) ([]Legacy, error) {
 	return legacyList, nil
 }
 
-// FindMaxBitrateURL 遍历variants数组，找到bitrate最大的元素并返回其URL
-func FindMaxBitrateURL(mediaInfo Media) string {
-	maxBitrate := 0
-	maxBitrateURL := ""
+// FindBestVariant 遍历variants数组，找到bitrate最大的元素
+// 如果只有HLS播放列表（没有bitrate），则返回播放列表
+func FindBestVariant(mediaInfo Media) (Variant, bool) {
+	maxBitrate := -1
+	var best, hls *Variant
 
-	for _, variant := range mediaInfo.VideoInfo.Variants {
+	for i, variant := range mediaInfo.VideoInfo.Variants {
+		if KindFromContentType(variant.ContentType) == "hls" {
+			if hls == nil {
+				hls = &mediaInfo.VideoInfo.Variants[i]
+			}
+			continue
+		}
 		if variant.Bitrate > maxBitrate {
 			maxBitrate = variant.Bitrate
-			maxBitrateURL = variant.URL
+			best = &mediaInfo.VideoInfo.Variants[i]
 		}
 	}
 
-	return maxBitrateURL
+	if best == nil {
+		best = hls
+	}
+	if best == nil {
+		return Variant{}, false
+	}
+	return *best, true
+}
+
+// FindMaxBitrateURL 遍历variants数组，找到bitrate最大的元素并返回其URL
+func FindMaxBitrateURL(mediaInfo Media) string {
+	variant, _ := FindBestVariant(mediaInfo)
+	return variant.URL
 }
 
 // Flatten 接受一个任意深度嵌套的切片，并返回一个扁平化的切片
@@ -169,7 +201,7 @@ func ParseTwitterTime(inputTime string) string {
 	isoDateLayout := "2006-01-02"
 	parsedTime, err := time.Parse(twitterTimeLayout, inputTime)
 	if err != nil {
-		log.Printf("Error parsing time: %v", err)
+		slog.Warn("parse twitter time", "input", inputTime, "err", err)
 		return ""
 	}
 	return parsedTime.Format(isoDateLayout)
@@ -186,3 +218,40 @@ func ExtractValueFromCookie(cookie string, fieldName string) string {
 	}
 	return ""
 }
+
+// ImageURLCandidates 生成原图的下载地址列表，按优先级排序：
+// 先尝试 extraFormats 中的格式（如 png、webp）的原图，再依次尝试原格式的 orig、4096x4096、large
+func ImageURLCandidates(imageURL string, extraFormats []string) []string {
+	u, err := url.Parse(imageURL)
+	if err != nil || u.Host != "pbs.twimg.com" {
+		return []string{imageURL}
+	}
+
+	format := strings.TrimPrefix(URLExt(imageURL), ".")
+	p, _, _ := strings.Cut(u.Path, ":")
+	basePath := strings.TrimSuffix(p, path.Ext(p))
+	if format == "" {
+		format = "jpg"
+	}
+
+	build := func(format string, name string) string {
+		candidate := url.URL{Scheme: "https", Host: u.Host, Path: basePath}
+		query := url.Values{}
+		query.Set("format", format)
+		query.Set("name", name)
+		candidate.RawQuery = query.Encode()
+		return candidate.String()
+	}
+
+	var candidates []string
+	for _, extra := range extraFormats {
+		extra = strings.ToLower(strings.TrimPrefix(extra, "."))
+		if extra != "" && extra != format {
+			candidates = append(candidates, build(extra, "orig"))
+		}
+	}
+	for _, name := range []string{"orig", "4096x4096", "large"} {
+		candidates = append(candidates, build(format, name))
+	}
+	return candidates
+}
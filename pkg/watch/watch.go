@@ -0,0 +1,111 @@
+package watch
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"math/rand"
+	"time"
+
+	"twitterDownload/pkg/storage"
+)
+
+// Target is one user polled by a Watcher
+type Target struct {
+	Name     string
+	Interval time.Duration
+}
+
+// Watcher polls targets on their own intervals until its context ends
+type Watcher struct {
+	// Clock defaults to RealClock
+	Clock Clock
+	// Jitter is the upper bound of a random delay added to every interval
+	Jitter time.Duration
+	// Poll crawls one target. Errors are logged and the target stays
+	// scheduled.
+	Poll func(ctx context.Context, target Target) error
+	// StatePath persists the schedule between runs, nothing is saved when
+	// empty
+	StatePath string
+	// Rand draws the jitter, seeded from the clock when nil
+	Rand *rand.Rand
+}
+
+// Run polls every target once it is due, starting with targets that were
+// never polled or are overdue. It returns nil once ctx is cancelled, after
+// the poll in progress has returned.
+func (w *Watcher) Run(ctx context.Context, targets []Target) error {
+	if len(targets) == 0 {
+		return errors.New("watch: no users to poll")
+	}
+	clock := w.Clock
+	if clock == nil {
+		clock = RealClock()
+	}
+	rnd := w.Rand
+	if rnd == nil {
+		rnd = rand.New(rand.NewSource(clock.Now().UnixNano()))
+	}
+
+	state := storage.WatchState{LastPoll: map[string]time.Time{}, NextPoll: map[string]time.Time{}}
+	if w.StatePath != "" {
+		loaded, err := storage.LoadWatchState(w.StatePath)
+		if err != nil {
+			slog.Warn("load watch state, starting a new schedule", "path", w.StatePath, "err", err)
+		} else {
+			state = loaded
+		}
+	}
+
+	due := make([]time.Time, len(targets))
+	for i, t := range targets {
+		due[i] = state.NextPoll[t.Name]
+	}
+
+	for {
+		next := 0
+		for i := range targets {
+			if due[i].Before(due[next]) {
+				next = i
+			}
+		}
+		target := targets[next]
+
+		if wait := due[next].Sub(clock.Now()); wait > 0 {
+			slog.Info("waiting for next poll", "user", target.Name, "at", due[next].Format(time.RFC3339))
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-clock.After(wait):
+			}
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		started := clock.Now()
+		slog.Info("polling user", "user", target.Name)
+		if err := w.Poll(ctx, target); err != nil {
+			slog.Warn("poll failed", "user", target.Name, "err", err)
+		}
+		// an interrupted poll keeps its old due time, so the next run
+		// starts with it
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		delay := target.Interval
+		if w.Jitter > 0 {
+			delay += time.Duration(rnd.Int63n(int64(w.Jitter)))
+		}
+		due[next] = clock.Now().Add(delay)
+		state.LastPoll[target.Name] = started
+		state.NextPoll[target.Name] = due[next]
+		if w.StatePath != "" {
+			if err := storage.SaveWatchState(w.StatePath, state); err != nil {
+				slog.Warn("save watch state", "path", w.StatePath, "err", err)
+			}
+		}
+	}
+}
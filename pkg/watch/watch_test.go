@@ -0,0 +1,147 @@
+package watch
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"twitterDownload/pkg/storage"
+)
+
+// waitFor polls cond until it holds, failing the test after a while
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestRunKeepsScheduleOfInterruptedPoll(t *testing.T) {
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	clock := NewFakeClock(start)
+
+	// the API answers the first poll of each user and hangs on the second
+	var mu sync.Mutex
+	requests := map[string]int{}
+	hanging := make(chan struct{})
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := r.URL.Query().Get("user")
+		mu.Lock()
+		requests[user]++
+		n := requests[user]
+		mu.Unlock()
+		if n > 1 {
+			close(hanging)
+			<-r.Context().Done()
+			return
+		}
+		w.Write([]byte(`{}`))
+	}))
+	defer api.Close()
+
+	statePath := filepath.Join(t.TempDir(), "watch.json")
+	w := Watcher{
+		Clock:     clock,
+		StatePath: statePath,
+		Rand:      rand.New(rand.NewSource(1)),
+		Poll: func(ctx context.Context, target Target) error {
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, api.URL+"?user="+target.Name, nil)
+			if err != nil {
+				return err
+			}
+			resp, err := api.Client().Do(req)
+			if err != nil {
+				return err
+			}
+			return resp.Body.Close()
+		},
+	}
+	targets := []Target{{Name: "alice", Interval: time.Hour}, {Name: "bob", Interval: 2 * time.Hour}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- w.Run(ctx, targets) }()
+
+	// both users are polled straight away, then alice is due in an hour
+	waitFor(t, "the first polls", func() bool { return clock.Waiters() == 1 })
+	state, err := storage.LoadWatchState(statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := state.NextPoll["alice"]; !got.Equal(start.Add(time.Hour)) {
+		t.Fatalf("alice due at %v, want %v", got, start.Add(time.Hour))
+	}
+
+	clock.Advance(time.Hour)
+	<-hanging
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	state, err = storage.LoadWatchState(statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := state.NextPoll["alice"]; !got.Equal(start.Add(time.Hour)) {
+		t.Errorf("after the interrupted poll alice is due at %v, want %v", got, start.Add(time.Hour))
+	}
+	if got := state.LastPoll["alice"]; !got.Equal(start) {
+		t.Errorf("alice last polled at %v, want %v", got, start)
+	}
+	if got := state.NextPoll["bob"]; !got.Equal(start.Add(2 * time.Hour)) {
+		t.Errorf("bob due at %v, want %v", got, start.Add(2*time.Hour))
+	}
+}
+
+func TestRunStartsWithOverdueTargets(t *testing.T) {
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	clock := NewFakeClock(start)
+	statePath := filepath.Join(t.TempDir(), "watch.json")
+	err := storage.SaveWatchState(statePath, storage.WatchState{
+		LastPoll: map[string]time.Time{},
+		NextPoll: map[string]time.Time{
+			"alice": start.Add(30 * time.Minute),
+			"bob":   start.Add(-time.Minute),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var polled []string
+	w := Watcher{
+		Clock:     clock,
+		StatePath: statePath,
+		Poll: func(ctx context.Context, target Target) error {
+			polled = append(polled, target.Name)
+			if len(polled) == 2 {
+				cancel()
+			}
+			return nil
+		},
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- w.Run(ctx, []Target{{Name: "alice", Interval: time.Hour}, {Name: "bob", Interval: time.Hour}})
+	}()
+
+	waitFor(t, "the wait for alice", func() bool { return clock.Waiters() == 1 })
+	clock.Advance(30 * time.Minute)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if len(polled) != 2 || polled[0] != "bob" || polled[1] != "alice" {
+		t.Errorf("polled %v, want bob then alice", polled)
+	}
+}